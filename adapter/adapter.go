@@ -1,9 +1,16 @@
 package adapter
 
+import (
+	"context"
+	"io"
+	"time"
+)
+
 // Adapter represents a Fly adapter.
+// Extended features are optional interfaces below, which the
+// Filesystem detects and emulates when an adapter lacks them.
 type Adapter interface {
-	CreateDir(string, ...interface{}) error
-	Copy(string, string) error
+	CreateDir(string, ...Option) error
 	Delete(string) error
 	DeleteDir(string) error
 	Has(string) (bool, error)
@@ -11,6 +18,112 @@ type Adapter interface {
 	MimeType(string) (string, error)
 	Read(string) (string, error)
 	ReadAndDelete(string) (string, error)
+	Write(string, string, ...Option) error
+}
+
+// ServerSideCopier represents a Fly adapter that can copy a file
+// without reading it through the client.
+type ServerSideCopier interface {
+	Copy(string, string) error
+}
+
+// Renamer represents a Fly adapter that can rename a file
+// without copying and deleting it through the client.
+type Renamer interface {
 	Rename(string, string) error
-	Write(string, string, ...interface{}) error
+}
+
+// Emulator represents a Fly adapter that implements optional interfaces with
+// other operations, e.g. Rename with Copy and Delete. Emulated returns the
+// names of those interfaces, like "Renamer".
+type Emulator interface {
+	Emulated() []string
+}
+
+// EmptyDirDeleter represents a Fly adapter that can delete a directory
+// only if it's empty, DeleteDir always deletes the whole directory.
+type EmptyDirDeleter interface {
+	DeleteEmptyDir(string) error
+}
+
+// Streamer represents a Fly adapter that can read and write
+// files as streams without buffering the whole file in memory.
+type Streamer interface {
+	ReadStream(string) (io.ReadCloser, error)
+	WriteStream(string, io.Reader, ...Option) error
+}
+
+// Lister represents a Fly adapter that can list the contents
+// of a directory, either shallow or recursive.
+type Lister interface {
+	ListContents(string, bool) ([]Entry, error)
+}
+
+// Stater represents a Fly adapter that can return the
+// metadata of a file in a single call.
+type Stater interface {
+	Stat(string) (FileInfo, error)
+}
+
+// ConditionalWriter represents a Fly adapter that can assert whether a file
+// exists as part of the write itself, without a racy Has before Write.
+// WriteNew fails with ErrExist and Update fails with ErrNotExist.
+type ConditionalWriter interface {
+	Update(string, string, ...Option) error
+	WriteNew(string, string, ...Option) error
+}
+
+// ContextAdapter represents a Fly adapter that can be bound to a context,
+// so every operation of the returned adapter is cancelled with it.
+type ContextAdapter interface {
+	WithContext(context.Context) Adapter
+}
+
+// Visibility represents a Fly adapter that can return and change
+// the visibility of a file, either VisibilityPublic or VisibilityPrivate.
+type Visibility interface {
+	Visibility(string) (string, error)
+	SetVisibility(string, string) error
+}
+
+// URLGenerator represents a Fly adapter that can return
+// a public URL for a file.
+type URLGenerator interface {
+	URL(string) (string, error)
+}
+
+// TemporaryURLGenerator represents a Fly adapter that can return a signed URL
+// that grants access to a file without credentials until it expires.
+// TemporaryURL returns a URL to download the file, the content type and
+// disposition options override the headers of the response. TemporaryUploadURL
+// returns a URL to upload the file with a PUT request, the options are part
+// of the signature so the request must send the matching headers.
+type TemporaryURLGenerator interface {
+	TemporaryURL(string, time.Duration, ...Option) (string, error)
+	TemporaryUploadURL(string, time.Duration, ...Option) (string, error)
+}
+
+// PostPolicy represents a HTML form that uploads a file straight to the
+// storage. The fields must be sent before the file in a multipart/form-data
+// POST request to the URL.
+type PostPolicy struct {
+	URL    string
+	Fields map[string]string
+}
+
+// PostPolicyGenerator represents a Fly adapter that can return a signed policy
+// for uploads from browsers with a HTML form. The file is uploaded to the
+// directory under the name it has on the client, with a size between the min
+// and max bytes, and the options are required form values. A max size of 0
+// allows any size.
+type PostPolicyGenerator interface {
+	PostPolicy(string, time.Duration, int64, int64, ...Option) (PostPolicy, error)
+}
+
+// RangeReader represents a Fly adapter that can read part of a file without
+// reading the whole file. ReadRange reads length bytes from the offset, or to
+// the end of the file with a negative length, and less when the file ends
+// before. A negative offset fails with ErrInvalidRange.
+type RangeReader interface {
+	ReadRange(string, int64, int64) (io.ReadCloser, error)
 }
@@ -0,0 +1,101 @@
+package adapter
+
+import "os"
+
+// Config represents the options used when writing files and creating
+// directories. Adapters use the fields their backend supports and
+// ignore the rest.
+type Config struct {
+	Permissions        os.FileMode
+	Visibility         string
+	ContentType        string
+	CacheControl       string
+	ContentDisposition string
+	Metadata           map[string]string
+	Encryption         *Encryption
+}
+
+// Encryption represents how the storage encrypts a file at rest.
+type Encryption struct {
+	// Algorithm is the server-side encryption algorithm, e.g. AES256 or aws:kms.
+	Algorithm string
+
+	// KeyID is the id of the key in a key management service.
+	KeyID string
+
+	// Context is authenticated with the key from the key management service.
+	Context map[string]string
+
+	// CustomerKey is a key provided by the client, which the storage
+	// doesn't keep, so it's required to read the file as well.
+	CustomerKey []byte
+}
+
+// Option represents a function that modifies the config.
+type Option func(*Config)
+
+// NewConfig creates a new config with the options applied.
+func NewConfig(opts ...Option) *Config {
+	c := &Config{}
+
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	return c
+}
+
+// WithPermissions sets the file mode, overrides the visibility for local files.
+func WithPermissions(perm os.FileMode) Option {
+	return func(c *Config) {
+		c.Permissions = perm
+	}
+}
+
+// WithVisibility sets the visibility, either VisibilityPublic or VisibilityPrivate.
+func WithVisibility(visibility string) Option {
+	return func(c *Config) {
+		c.Visibility = visibility
+	}
+}
+
+// WithContentType sets the content type instead of guessing it from the extension.
+func WithContentType(typ string) Option {
+	return func(c *Config) {
+		c.ContentType = typ
+	}
+}
+
+// WithCacheControl sets the Cache-Control header.
+func WithCacheControl(value string) Option {
+	return func(c *Config) {
+		c.CacheControl = value
+	}
+}
+
+// WithContentDisposition sets the Content-Disposition header.
+func WithContentDisposition(value string) Option {
+	return func(c *Config) {
+		c.ContentDisposition = value
+	}
+}
+
+// WithMetadata adds user defined metadata.
+func WithMetadata(metadata map[string]string) Option {
+	return func(c *Config) {
+		if c.Metadata == nil {
+			c.Metadata = map[string]string{}
+		}
+
+		for k, v := range metadata {
+			c.Metadata[k] = v
+		}
+	}
+}
+
+// WithEncryption sets the server-side encryption of the file.
+func WithEncryption(e Encryption) Option {
+	return func(c *Config) {
+		c.Encryption = &e
+	}
+}
@@ -0,0 +1,22 @@
+package adapter
+
+import "time"
+
+// Entry types.
+const (
+	TypeFile = "file"
+	TypeDir  = "dir"
+)
+
+// Entry represents a file or directory in a directory listing.
+type Entry struct {
+	Path         string
+	Type         string
+	Size         int64
+	LastModified time.Time
+}
+
+// IsDir will check whether the entry is a directory.
+func (e Entry) IsDir() bool {
+	return e.Type == TypeDir
+}
@@ -0,0 +1,43 @@
+package adapter
+
+import (
+	"errors"
+	"os"
+)
+
+// Errors returned by adapters, wrapped in a *PathError so callers can
+// check them with errors.Is regardless of the adapter in use.
+//
+// ErrNotExist, ErrExist and ErrPermission are the same values as the
+// os and io/fs errors, so errors.Is(err, os.ErrNotExist) works as well.
+var (
+	ErrNotExist          = os.ErrNotExist
+	ErrExist             = os.ErrExist
+	ErrPermission        = os.ErrPermission
+	ErrIsDir             = errors.New("is a directory")
+	ErrNotDir            = errors.New("not a directory")
+	ErrInvalidPath       = errors.New("invalid path")
+	ErrInvalidVisibility = errors.New("invalid visibility")
+	ErrInvalidRange      = errors.New("invalid range")
+	ErrDirNotEmpty       = errors.New("directory not empty")
+	ErrReadOnly          = errors.New("read-only filesystem")
+	ErrNotSupported      = errors.New("operation not supported")
+)
+
+// PathError records an error and the operation, path and adapter that caused it.
+type PathError struct {
+	Op      string
+	Path    string
+	Adapter string
+	Err     error
+}
+
+// Error returns the error message.
+func (e *PathError) Error() string {
+	return e.Adapter + ": " + e.Op + " " + e.Path + ": " + e.Err.Error()
+}
+
+// Unwrap returns the underlying error.
+func (e *PathError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,20 @@
+package adapter
+
+import "time"
+
+// Visibility values.
+const (
+	VisibilityPublic  = "public"
+	VisibilityPrivate = "private"
+)
+
+// FileInfo represents the metadata of a file.
+// Fields the adapter can't tell are left empty.
+type FileInfo struct {
+	Path         string
+	Size         int64
+	LastModified time.Time
+	MimeType     string
+	ETag         string
+	Visibility   string
+}
@@ -0,0 +1,263 @@
+package flyiofs
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"mime"
+	"path"
+	"strings"
+
+	"github.com/frozzare/go-fly/adapter"
+)
+
+// Adapter represents a read only adapter on top of a io/fs.FS,
+// e.g. a embed.FS, a *zip.Reader or a fstest.MapFS.
+type Adapter struct {
+	fs fs.FS
+}
+
+// NewAdapter creates a new io/fs adapter.
+func NewAdapter(fsys fs.FS) *Adapter {
+	return &Adapter{fs: fsys}
+}
+
+// name maps a adapter path onto a io/fs name.
+func name(p string) (string, error) {
+	p = strings.Trim(p, "/")
+	if p == "" {
+		return ".", nil
+	}
+
+	if !fs.ValidPath(p) {
+		return "", adapter.ErrInvalidPath
+	}
+
+	return p, nil
+}
+
+// CreateDir will return adapter.ErrReadOnly.
+func (a *Adapter) CreateDir(path string, opts ...adapter.Option) error {
+	return wrapError("createdir", path, adapter.ErrReadOnly)
+}
+
+// Delete will return adapter.ErrReadOnly.
+func (a *Adapter) Delete(path string) error {
+	return wrapError("delete", path, adapter.ErrReadOnly)
+}
+
+// DeleteDir will return adapter.ErrReadOnly.
+func (a *Adapter) DeleteDir(path string) error {
+	return wrapError("deletedir", path, adapter.ErrReadOnly)
+}
+
+// Has will check whether a file exists.
+func (a *Adapter) Has(path string) (bool, error) {
+	info, err := a.stat("has", path)
+	if errors.Is(err, adapter.ErrNotExist) {
+		return false, nil
+	}
+
+	if err != nil {
+		return false, err
+	}
+
+	return !info.IsDir(), nil
+}
+
+// HasDir will check whether a directory exists.
+func (a *Adapter) HasDir(path string) (bool, error) {
+	info, err := a.stat("hasdir", path)
+	if errors.Is(err, adapter.ErrNotExist) {
+		return false, nil
+	}
+
+	if err != nil {
+		return false, err
+	}
+
+	return info.IsDir(), nil
+}
+
+// ListContents will list the files and directories in a directory.
+func (a *Adapter) ListContents(dir string, recursive bool) ([]adapter.Entry, error) {
+	root, err := name(dir)
+	if err != nil {
+		return nil, wrapError("list", dir, err)
+	}
+
+	entries := []adapter.Entry{}
+
+	err = fs.WalkDir(a.fs, root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if p == root {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		entry := adapter.Entry{
+			Path:         p,
+			Type:         adapter.TypeFile,
+			Size:         info.Size(),
+			LastModified: info.ModTime(),
+		}
+
+		if d.IsDir() {
+			entry.Type = adapter.TypeDir
+			entry.Size = 0
+		}
+
+		entries = append(entries, entry)
+
+		if d.IsDir() && !recursive {
+			return fs.SkipDir
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, wrapError("list", dir, err)
+	}
+
+	return entries, nil
+}
+
+// MimeType will return the file mime type.
+func (a *Adapter) MimeType(p string) (string, error) {
+	return strings.Split(mime.TypeByExtension(path.Ext(p)), ";")[0], nil
+}
+
+// Read will read a file.
+func (a *Adapter) Read(path string) (string, error) {
+	n, err := name(path)
+	if err != nil {
+		return "", wrapError("read", path, err)
+	}
+
+	content, err := fs.ReadFile(a.fs, n)
+	if err != nil {
+		return "", wrapError("read", path, err)
+	}
+
+	return string(content), nil
+}
+
+// ReadRange will open a file for reading length bytes from the offset, a
+// negative length reads to the end of the file. Files that implement io.Seeker
+// seek to the offset, the offset of other files is skipped.
+func (a *Adapter) ReadRange(path string, offset, length int64) (io.ReadCloser, error) {
+	if offset < 0 {
+		return nil, wrapError("readrange", path, adapter.ErrInvalidRange)
+	}
+
+	n, err := name(path)
+	if err != nil {
+		return nil, wrapError("readrange", path, err)
+	}
+
+	file, err := a.fs.Open(n)
+	if err != nil {
+		return nil, wrapError("readrange", path, err)
+	}
+
+	if s, ok := file.(io.Seeker); ok {
+		_, err = s.Seek(offset, io.SeekStart)
+	} else if _, err = io.CopyN(ioutil.Discard, file, offset); err == io.EOF {
+		err = nil
+	}
+
+	if err != nil {
+		file.Close()
+		return nil, wrapError("readrange", path, err)
+	}
+
+	if length < 0 {
+		return file, nil
+	}
+
+	return &struct {
+		io.Reader
+		io.Closer
+	}{io.LimitReader(file, length), file}, nil
+}
+
+// ReadAndDelete will return adapter.ErrReadOnly.
+func (a *Adapter) ReadAndDelete(path string) (string, error) {
+	return "", wrapError("readanddelete", path, adapter.ErrReadOnly)
+}
+
+// Stat will return the metadata of a file.
+// The ETag is derived from the modification time and size.
+func (a *Adapter) Stat(path string) (adapter.FileInfo, error) {
+	info, err := a.stat("stat", path)
+	if err != nil {
+		return adapter.FileInfo{}, err
+	}
+
+	if info.IsDir() {
+		return adapter.FileInfo{}, wrapError("stat", path, adapter.ErrIsDir)
+	}
+
+	typ, err := a.MimeType(path)
+	if err != nil {
+		return adapter.FileInfo{}, err
+	}
+
+	return adapter.FileInfo{
+		Path:         path,
+		Size:         info.Size(),
+		LastModified: info.ModTime(),
+		MimeType:     typ,
+		ETag:         fmt.Sprintf("%x-%x", info.ModTime().UnixNano(), info.Size()),
+	}, nil
+}
+
+func (a *Adapter) stat(op, path string) (fs.FileInfo, error) {
+	n, err := name(path)
+	if err != nil {
+		return nil, wrapError(op, path, err)
+	}
+
+	info, err := fs.Stat(a.fs, n)
+	if err != nil {
+		return nil, wrapError(op, path, err)
+	}
+
+	return info, nil
+}
+
+// Write will return adapter.ErrReadOnly.
+func (a *Adapter) Write(path, content string, opts ...adapter.Option) error {
+	return wrapError("write", path, adapter.ErrReadOnly)
+}
+
+// wrapError maps a io/fs error onto the adapter errors
+// and wraps it in a *adapter.PathError.
+func wrapError(op, path string, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		err = adapter.ErrNotExist
+	case errors.Is(err, fs.ErrExist):
+		err = adapter.ErrExist
+	case errors.Is(err, fs.ErrPermission):
+		err = adapter.ErrPermission
+	case errors.Is(err, fs.ErrInvalid):
+		err = adapter.ErrInvalidPath
+	}
+
+	return &adapter.PathError{Op: op, Path: path, Adapter: "flyiofs", Err: err}
+}
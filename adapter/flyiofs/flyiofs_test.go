@@ -0,0 +1,142 @@
+package flyiofs
+
+import (
+	"errors"
+	"io/ioutil"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/frozzare/go-assert"
+	"github.com/frozzare/go-fly"
+	"github.com/frozzare/go-fly/adapter"
+	"github.com/frozzare/go-fly/flytest"
+)
+
+var mapFS = fstest.MapFS{
+	"test/hello.txt":     {Data: []byte("Hello, world!"), ModTime: time.Unix(1504641539, 0)},
+	"test/sub/fly.html":  {Data: []byte("<h1>Fly</h1>")},
+	"test/empty/.keep":   {},
+	"static/css/app.css": {Data: []byte("body {}")},
+}
+
+func TestDirectory(t *testing.T) {
+	fs := NewAdapter(mapFS)
+
+	has, err := fs.HasDir("test/sub")
+	assert.True(t, has)
+	assert.Nil(t, err)
+
+	has, err = fs.HasDir("test/hello.txt")
+	assert.False(t, has)
+	assert.Nil(t, err)
+
+	has, err = fs.HasDir("missing")
+	assert.False(t, has)
+	assert.Nil(t, err)
+
+	entries, err := fs.ListContents("test", false)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(entries))
+	assert.Equal(t, "test/empty", entries[0].Path)
+	assert.True(t, entries[0].IsDir())
+	assert.Equal(t, "test/hello.txt", entries[1].Path)
+	assert.Equal(t, int64(13), entries[1].Size)
+
+	entries, err = fs.ListContents("", true)
+	assert.Nil(t, err)
+	assert.Equal(t, 9, len(entries))
+}
+
+func TestFile(t *testing.T) {
+	fs := NewAdapter(mapFS)
+
+	has, err := fs.Has("test/hello.txt")
+	assert.True(t, has)
+	assert.Nil(t, err)
+
+	has, err = fs.Has("test/missing.txt")
+	assert.False(t, has)
+	assert.Nil(t, err)
+
+	content, err := fs.Read("test/hello.txt")
+	assert.Nil(t, err)
+	assert.Equal(t, "Hello, world!", content)
+
+	r, err := fs.ReadRange("test/sub/fly.html", 0, -1)
+	assert.Nil(t, err)
+
+	buf, err := ioutil.ReadAll(r)
+	assert.Nil(t, err)
+	assert.Nil(t, r.Close())
+	assert.Equal(t, "<h1>Fly</h1>", string(buf))
+
+	r, err = fs.ReadRange("test/hello.txt", 7, 5)
+	assert.Nil(t, err)
+
+	buf, err = ioutil.ReadAll(r)
+	assert.Nil(t, err)
+	assert.Nil(t, r.Close())
+	assert.Equal(t, "world", string(buf))
+
+	_, err = fs.ReadRange("test/hello.txt", -1, 5)
+	assert.True(t, errors.Is(err, adapter.ErrInvalidRange))
+
+	_, err = fs.Read("test/missing.txt")
+	assert.True(t, errors.Is(err, adapter.ErrNotExist))
+
+	info, err := fs.Stat("test/hello.txt")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(13), info.Size)
+	assert.Equal(t, "text/plain", info.MimeType)
+	assert.Equal(t, time.Unix(1504641539, 0), info.LastModified)
+
+	// Changes within the same second must change the ETag.
+	changed, err := NewAdapter(fstest.MapFS{
+		"test/hello.txt": {Data: []byte("Hello, there!"), ModTime: time.Unix(1504641539, 1)},
+	}).Stat("test/hello.txt")
+	assert.Nil(t, err)
+	assert.NotEqual(t, info.ETag, changed.ETag)
+}
+
+func TestReadOnly(t *testing.T) {
+	fs := fly.NewFly(NewAdapter(mapFS))
+
+	assert.True(t, errors.Is(fs.Write("test/new.txt", "new"), adapter.ErrReadOnly))
+	assert.True(t, errors.Is(fs.WriteNew("test/new.txt", "new"), adapter.ErrReadOnly))
+	assert.True(t, errors.Is(fs.Copy("test/hello.txt", "test/copy.txt"), adapter.ErrReadOnly))
+	assert.True(t, errors.Is(fs.Rename("test/hello.txt", "test/copy.txt"), adapter.ErrReadOnly))
+	assert.True(t, errors.Is(fs.Delete("test/hello.txt"), adapter.ErrReadOnly))
+	assert.True(t, errors.Is(fs.DeleteDir("test"), adapter.ErrReadOnly))
+	assert.True(t, errors.Is(fs.CreateDir("test/new"), adapter.ErrReadOnly))
+
+	_, err := fs.ReadAndDelete("test/hello.txt")
+	assert.True(t, errors.Is(err, adapter.ErrReadOnly))
+
+	has, err := fs.Has("test/hello.txt")
+	assert.True(t, has)
+	assert.Nil(t, err)
+}
+
+func TestCapabilities(t *testing.T) {
+	c := fly.NewFly(NewAdapter(mapFS)).Capabilities()
+	assert.True(t, c.Lister)
+	assert.True(t, c.RangeReader)
+	assert.True(t, c.Stater)
+	assert.False(t, c.Renamer)
+	assert.False(t, c.ServerSideCopier)
+	assert.False(t, c.Streamer)
+}
+
+func TestFS(t *testing.T) {
+	fs := fly.NewFly(NewAdapter(mapFS))
+
+	err := fstest.TestFS(fs.FS(), "test/hello.txt", "test/sub/fly.html", "static/css/app.css")
+	assert.Nil(t, err)
+}
+
+func TestSuite(t *testing.T) {
+	flytest.RunAdapterSuite(t, func() adapter.Adapter {
+		return NewAdapter(mapFS)
+	})
+}
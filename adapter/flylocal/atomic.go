@@ -0,0 +1,154 @@
+package flylocal
+
+import (
+	"io"
+	"io/fs"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/frozzare/go-fly/adapter"
+)
+
+// tempPrefix is the name prefix of the temporary files used by atomic writes.
+const tempPrefix = ".flylocal-tmp-"
+
+// tempMaxAge is how long a temporary file can be left unmodified before it's
+// considered left behind by a crash, so writes in progress aren't removed.
+const tempMaxAge = time.Hour
+
+// writeAtomic writes the reader to a temporary file in the same directory and
+// renames it over the target. With O_EXCL the temporary file is linked to the
+// target instead, which fails when the target exists.
+func (a *Adapter) writeAtomic(root *os.Root, name string, r io.Reader, flag int, cfg *adapter.Config, perm os.FileMode) error {
+	// Existing files keep their mode unless it's set explicitly,
+	// like when the file is opened without O_CREATE.
+	info, err := root.Stat(name)
+	switch {
+	case err == nil && info.IsDir():
+		return adapter.ErrIsDir
+	case err == nil && flag&os.O_EXCL != 0:
+		return adapter.ErrExist
+	case err == nil && cfg.Permissions == 0 && cfg.Visibility == "":
+		perm = info.Mode().Perm()
+	case err != nil && (flag&os.O_CREATE == 0 || !os.IsNotExist(err)):
+		return err
+	}
+
+	tmp, tmpName, err := createTemp(root, filepath.Dir(name))
+	if err != nil {
+		return err
+	}
+
+	defer root.Remove(tmpName)
+
+	if err := a.writeTemp(tmp, r, perm); err != nil {
+		return err
+	}
+
+	if flag&os.O_EXCL != 0 {
+		err = root.Link(tmpName, name)
+	} else {
+		err = root.Rename(tmpName, name)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return a.syncDir(root, filepath.Dir(name))
+}
+
+// createTemp creates a new temporary file with a random name in the directory.
+func createTemp(root *os.Root, dir string) (*os.File, string, error) {
+	for i := 0; ; i++ {
+		name := filepath.Join(dir, tempPrefix+strconv.FormatUint(uint64(rand.Uint32()), 10))
+
+		file, err := root.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0600)
+		if os.IsExist(err) && i < 10000 {
+			continue
+		}
+
+		return file, name, err
+	}
+}
+
+// writeTemp copies the reader to the temporary file and closes it.
+func (a *Adapter) writeTemp(tmp *os.File, r io.Reader, perm os.FileMode) error {
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if _, err := copyContext(a.ctx, tmp, r); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := a.sync(tmp); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	return tmp.Close()
+}
+
+// sync syncs the file to disk with DurabilityFile or higher.
+func (a *Adapter) sync(file *os.File) error {
+	if a.durability < DurabilityFile {
+		return nil
+	}
+
+	return file.Sync()
+}
+
+// syncDir syncs the directory to disk with DurabilityFileAndDir,
+// so the directory entry of a created or renamed file is persisted.
+func (a *Adapter) syncDir(root *os.Root, dir string) error {
+	if a.durability < DurabilityFileAndDir {
+		return nil
+	}
+
+	d, err := root.Open(dir)
+	if err != nil {
+		return err
+	}
+
+	if err := d.Sync(); err != nil {
+		d.Close()
+		return err
+	}
+
+	return d.Close()
+}
+
+// removeTempFiles removes the temporary files left behind by a crash.
+// It's best effort, since the adapter works without it.
+func (a *Adapter) removeTempFiles() {
+	root, err := os.OpenRoot(a.path)
+	if err != nil {
+		return
+	}
+
+	defer root.Close()
+
+	fs.WalkDir(root.FS(), ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() || !isTemp(d.Name()) {
+			return nil
+		}
+
+		if info, err := d.Info(); err == nil && time.Since(info.ModTime()) > tempMaxAge {
+			root.Remove(filepath.FromSlash(name))
+		}
+
+		return nil
+	})
+}
+
+// isTemp checks whether the name is a temporary file of a atomic write.
+func isTemp(name string) bool {
+	return strings.HasPrefix(name, tempPrefix)
+}
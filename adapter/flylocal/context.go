@@ -0,0 +1,27 @@
+package flylocal
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+)
+
+// contextReader checks the context for cancellation before every read.
+type contextReader struct {
+	io.ReadCloser
+	ctx context.Context
+}
+
+func (r *contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	return r.ReadCloser.Read(p)
+}
+
+// copyContext copies from src to dst in chunks and
+// stops with the context error when it's cancelled.
+func copyContext(ctx context.Context, dst io.Writer, src io.Reader) (int64, error) {
+	return io.Copy(dst, &contextReader{ReadCloser: ioutil.NopCloser(src), ctx: ctx})
+}
@@ -1,6 +1,8 @@
 package flylocal
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -8,115 +10,351 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"syscall"
+	"time"
+
+	"github.com/frozzare/go-fly/adapter"
+)
+
+// rename is replaced in tests to simulate paths on different devices.
+var rename = (*os.Root).Rename
+
+// Durability represents how written files are synced to disk.
+type Durability int
+
+// Durability levels.
+const (
+	// DurabilityNone leaves flushing written files to the operating system.
+	DurabilityNone Durability = iota
+
+	// DurabilityFile syncs the file before it's closed.
+	DurabilityFile
+
+	// DurabilityFileAndDir syncs the file and its directory,
+	// so a created or renamed file survives a crash as well.
+	DurabilityFileAndDir
 )
 
-// Adapter represents a local adapter.
+// Option represents a function that configures the adapter.
+type Option func(*Adapter)
+
+// WithAtomicWrites makes the adapter write files to a temporary file in
+// the same directory that's renamed over the target when it's complete,
+// so readers never see a half-written file.
+func WithAtomicWrites() Option {
+	return func(a *Adapter) {
+		a.atomic = true
+	}
+}
+
+// WithDurability sets how written files are synced to disk,
+// the default is DurabilityNone.
+func WithDurability(d Durability) Option {
+	return func(a *Adapter) {
+		a.durability = d
+	}
+}
+
+// Adapter represents a local adapter. Every operation is confined to
+// the path, paths that resolve outside it fail with adapter.ErrInvalidPath.
 type Adapter struct {
-	path string
+	atomic     bool
+	ctx        context.Context
+	durability Durability
+	path       string
+	symlinks   SymlinkPolicy
 }
 
 // NewAdapter creates a new local adapter.
-func NewAdapter(path string) *Adapter {
-	return &Adapter{path}
+// With atomic writes temporary files left behind by
+// a crash are removed from the path on creation.
+func NewAdapter(path string, opts ...Option) *Adapter {
+	a := &Adapter{ctx: context.Background(), path: path}
+
+	for _, opt := range opts {
+		opt(a)
+	}
+
+	if a.atomic {
+		a.removeTempFiles()
+	}
+
+	return a
 }
 
-func (a *Adapter) appendPath(path string) string {
-	return filepath.Join(a.path, path)
+// WithContext returns a copy of the adapter that checks the context
+// for cancellation before reads and writes and between copied chunks.
+func (a *Adapter) WithContext(ctx context.Context) adapter.Adapter {
+	a2 := *a
+	a2.ctx = ctx
+	return &a2
 }
 
-// Copy will copy a file to new path locally.
+// Copy will copy a file to new path locally,
+// preserving the permissions and modification time.
 func (a *Adapter) Copy(src string, dst string) error {
-	srcFile, err := os.Open(a.appendPath(src))
+	if err := a.ctx.Err(); err != nil {
+		return wrapError("copy", src, err)
+	}
+
+	root, srcName, err := a.open(src, false)
 	if err != nil {
-		return err
+		return wrapError("copy", src, err)
+	}
+
+	defer root.Close()
+
+	dstName, err := a.name(root, dst)
+	if err != nil {
+		return wrapError("copy", dst, err)
+	}
+
+	return a.copy("copy", root, src, srcName, dst, dstName)
+}
+
+// copy copies the file and creates the parent directories of the destination,
+// copying a file onto itself does nothing.
+func (a *Adapter) copy(op string, root *os.Root, src, srcName, dst, dstName string) error {
+	srcFile, err := root.Open(srcName)
+	if err != nil {
+		return wrapError(op, src, err)
 	}
 
 	defer srcFile.Close()
 
 	sfi, err := srcFile.Stat()
 	if err != nil {
-		return err
+		return wrapError(op, src, err)
 	}
 
 	if !sfi.Mode().IsRegular() {
-		return fmt.Errorf("%s is not a regular file", src)
+		return wrapError(op, src, adapter.ErrIsDir)
 	}
 
-	destFile, err := os.Create(a.appendPath(dst))
-	if err != nil {
-		return err
+	// Opening the destination truncates it, which would empty the source.
+	if srcName == dstName {
+		return nil
+	}
+
+	if dfi, err := root.Stat(dstName); err == nil && os.SameFile(sfi, dfi) {
+		return nil
 	}
 
-	dfi, err := destFile.Stat()
+	if err := mkdirAll(root, filepath.Dir(dstName), 0777); err != nil {
+		return wrapError(op, dst, err)
+	}
+
+	destFile, err := root.OpenFile(dstName, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, sfi.Mode().Perm())
 	if err != nil {
-		return err
+		return wrapError(op, dst, err)
 	}
 
-	if !dfi.Mode().IsRegular() {
-		return fmt.Errorf("%s is not a regular file", dst)
+	// The mode is only used when the file is created and
+	// the umask applies to it, so it's changed explicitly.
+	if err := destFile.Chmod(sfi.Mode().Perm()); err != nil {
+		destFile.Close()
+		return wrapError(op, dst, err)
 	}
 
-	defer destFile.Close()
+	if _, err := copyContext(a.ctx, destFile, srcFile); err != nil {
+		destFile.Close()
+		return wrapError(op, dst, err)
+	}
 
-	if _, err := io.Copy(destFile, srcFile); err != nil {
-		return err
+	if err := destFile.Close(); err != nil {
+		return wrapError(op, dst, err)
 	}
 
-	return nil
+	return wrapError(op, dst, root.Chtimes(dstName, time.Now(), sfi.ModTime()))
 }
 
 // CreateDir will create a directory.
-func (a *Adapter) CreateDir(path string, args ...interface{}) error {
-	perm := uint32(0777)
-	if len(args) > 0 {
-		perm = args[0].(uint32)
+// The permissions default to 0777 before umask, or to 0755 and 0700
+// for public and private visibility.
+func (a *Adapter) CreateDir(path string, opts ...adapter.Option) error {
+	perm := fileMode(adapter.NewConfig(opts...), 0755, 0700, 0777)
+
+	root, name, err := a.open(path, true)
+	if err != nil {
+		return wrapError("createdir", path, err)
 	}
 
-	return os.MkdirAll(strings.TrimRight(a.appendPath(path), "/")+"/", os.FileMode(perm))
+	defer root.Close()
+
+	return wrapError("createdir", path, mkdirAll(root, name, perm))
 }
 
-// Delete will delete a file locally.
+// Delete will delete a file locally, with SymlinkAsFile
+// a symlink is deleted rather than what it points to.
 func (a *Adapter) Delete(path string) error {
-	if err := os.Remove(a.appendPath(path)); err != nil {
-		return err
+	root, name, err := a.open(path, false)
+	if err != nil {
+		return wrapError("delete", path, err)
 	}
 
-	return nil
+	defer root.Close()
+
+	return wrapError("delete", path, root.Remove(name))
 }
 
-// DeleteDir will delete a directory.
+// DeleteDir will delete a directory and everything in it.
 func (a *Adapter) DeleteDir(path string) error {
-	return a.Delete(strings.TrimRight(path, "/") + "/")
+	root, name, err := a.open(path, false)
+	if err != nil {
+		return wrapError("deletedir", path, err)
+	}
+
+	defer root.Close()
+
+	if err := a.isDir(root, name); err != nil {
+		return wrapError("deletedir", path, err)
+	}
+
+	return wrapError("deletedir", path, root.RemoveAll(name))
+}
+
+// DeleteEmptyDir will delete a directory only if it's empty.
+func (a *Adapter) DeleteEmptyDir(path string) error {
+	root, name, err := a.open(path, false)
+	if err != nil {
+		return wrapError("deleteemptydir", path, err)
+	}
+
+	defer root.Close()
+
+	if err := a.isDir(root, name); err != nil {
+		return wrapError("deleteemptydir", path, err)
+	}
+
+	return wrapError("deleteemptydir", path, root.Remove(name))
+}
+
+// isDir returns a error unless the name is a existing directory.
+func (a *Adapter) isDir(root *os.Root, name string) error {
+	info, err := a.stat(root, name)
+	if err != nil {
+		return err
+	}
+
+	if !info.IsDir() {
+		return adapter.ErrNotDir
+	}
+
+	return nil
 }
 
 // Has will check whether a file exists.
 func (a *Adapter) Has(path string) (bool, error) {
-	_, err := os.Stat(a.appendPath(path))
-	return err == nil, nil
+	info, err := a.lookup("has", path)
+	return info != nil && !info.IsDir(), err
 }
 
 // HasDir will check whether a directory exists.
 func (a *Adapter) HasDir(path string) (bool, error) {
-	return a.Has(strings.TrimRight(path, "/") + "/")
+	info, err := a.lookup("hasdir", path)
+	return info != nil && info.IsDir(), err
+}
+
+// lookup returns the file info, or nil without a error when the path doesn't exist.
+func (a *Adapter) lookup(op, path string) (os.FileInfo, error) {
+	root, name, err := a.open(path, false)
+	if err == nil {
+		defer root.Close()
+
+		var info os.FileInfo
+		if info, err = a.stat(root, name); err == nil {
+			return info, nil
+		}
+	}
+
+	if err = wrapError(op, path, err); errors.Is(err, adapter.ErrNotExist) || errors.Is(err, adapter.ErrNotDir) {
+		return nil, nil
+	}
+
+	return nil, err
+}
+
+// ListContents will list the files and directories in a directory locally.
+// Temporary files of atomic writes in progress are left out.
+func (a *Adapter) ListContents(dir string, recursive bool) ([]adapter.Entry, error) {
+	root, name, err := a.open(dir, false)
+	if err != nil {
+		return nil, wrapError("list", dir, err)
+	}
+
+	defer root.Close()
+
+	entries := []adapter.Entry{}
+
+	err = a.walk(root, name, recursive, func(name string, info os.FileInfo) {
+		entries = append(entries, a.entry(name, info))
+	})
+
+	if err != nil {
+		return nil, wrapError("list", dir, err)
+	}
+
+	return entries, nil
+}
+
+func (a *Adapter) entry(name string, info os.FileInfo) adapter.Entry {
+	entry := adapter.Entry{
+		Path:         filepath.ToSlash(name),
+		Type:         adapter.TypeFile,
+		Size:         info.Size(),
+		LastModified: info.ModTime(),
+	}
+
+	if info.IsDir() {
+		entry.Type = adapter.TypeDir
+		entry.Size = 0
+	}
+
+	return entry
 }
 
 // MimeType will return the file mime type.
 func (a *Adapter) MimeType(path string) (string, error) {
-	return strings.Split(mime.TypeByExtension(filepath.Ext(a.appendPath(path))), ";")[0], nil
+	return strings.Split(mime.TypeByExtension(filepath.Ext(path)), ";")[0], nil
 }
 
 // Read will read a file locally.
 func (a *Adapter) Read(path string) (string, error) {
-	if _, err := a.Has(path); err != nil {
+	r, err := a.ReadStream(path)
+	if err != nil {
 		return "", err
 	}
 
-	content, err := ioutil.ReadFile(a.appendPath(path))
+	defer r.Close()
+
+	content, err := ioutil.ReadAll(r)
 	if err != nil {
-		return "", err
+		return "", wrapError("read", path, err)
 	}
 
-	return string(content), err
+	return string(content), nil
+}
+
+// ReadStream will open a file locally for reading.
+func (a *Adapter) ReadStream(path string) (io.ReadCloser, error) {
+	if err := a.ctx.Err(); err != nil {
+		return nil, wrapError("read", path, err)
+	}
+
+	root, name, err := a.open(path, false)
+	if err != nil {
+		return nil, wrapError("read", path, err)
+	}
+
+	// The opened file stays usable after the root is closed.
+	defer root.Close()
+
+	file, err := root.Open(name)
+	if err != nil {
+		return nil, wrapError("read", path, err)
+	}
+
+	return &contextReader{ctx: a.ctx, ReadCloser: file}, nil
 }
 
 // ReadAndDelete will read a file and delete it if any.
@@ -127,34 +365,267 @@ func (a *Adapter) ReadAndDelete(path string) (string, error) {
 		return "", err
 	}
 
-	if err := a.Delete(path); err == nil {
-		return content, nil
+	if err := a.Delete(path); err != nil {
+		return "", err
 	}
 
-	return "", err
+	return content, nil
 }
 
-// Rename will rename a file to a new path locally.
+// Rename will rename a file to a new path locally with os.Rename and creates
+// the parent directories of the destination. The file is only copied and
+// deleted when the paths are on different devices.
 func (a *Adapter) Rename(src, dst string) error {
-	if err := a.Copy(src, dst); err != nil {
+	if err := a.ctx.Err(); err != nil {
+		return wrapError("rename", src, err)
+	}
+
+	root, srcName, err := a.open(src, false)
+	if err != nil {
+		return wrapError("rename", src, err)
+	}
+
+	defer root.Close()
+
+	dstName, err := a.name(root, dst)
+	if err != nil {
+		return wrapError("rename", dst, err)
+	}
+
+	info, err := a.stat(root, srcName)
+	if err != nil {
+		return wrapError("rename", src, err)
+	}
+
+	if info.IsDir() {
+		return wrapError("rename", src, adapter.ErrIsDir)
+	}
+
+	if err := mkdirAll(root, filepath.Dir(dstName), 0777); err != nil {
+		return wrapError("rename", dst, err)
+	}
+
+	err = rename(root, srcName, dstName)
+	if !errors.Is(err, syscall.EXDEV) {
+		return wrapError("rename", src, err)
+	}
+
+	if err := a.copy("rename", root, src, srcName, dst, dstName); err != nil {
 		return err
 	}
 
-	return a.Delete(src)
+	return wrapError("rename", src, root.Remove(srcName))
+}
+
+// SetVisibility will change the permissions of a file to 0644 for public
+// and 0600 for private visibility, directories get 0755 and 0700.
+func (a *Adapter) SetVisibility(path, visibility string) error {
+	root, name, err := a.open(path, false)
+	if err != nil {
+		return wrapError("setvisibility", path, err)
+	}
+
+	defer root.Close()
+
+	info, err := root.Stat(name)
+	if err != nil {
+		return wrapError("setvisibility", path, err)
+	}
+
+	cfg := adapter.NewConfig(adapter.WithVisibility(visibility))
+
+	perm := fileMode(cfg, 0644, 0600, 0)
+	if info.IsDir() {
+		perm = fileMode(cfg, 0755, 0700, 0)
+	}
+
+	if perm == 0 {
+		return wrapError("setvisibility", path, adapter.ErrInvalidVisibility)
+	}
+
+	return wrapError("setvisibility", path, root.Chmod(name, perm))
+}
+
+// Stat will return the metadata of a file locally.
+// The ETag is derived from the modification time and size.
+func (a *Adapter) Stat(path string) (adapter.FileInfo, error) {
+	root, name, err := a.open(path, false)
+	if err != nil {
+		return adapter.FileInfo{}, wrapError("stat", path, err)
+	}
+
+	defer root.Close()
+
+	info, err := a.stat(root, name)
+	if err != nil {
+		return adapter.FileInfo{}, wrapError("stat", path, err)
+	}
+
+	if info.IsDir() {
+		return adapter.FileInfo{}, wrapError("stat", path, adapter.ErrIsDir)
+	}
+
+	typ, err := a.MimeType(path)
+	if err != nil {
+		return adapter.FileInfo{}, err
+	}
+
+	return adapter.FileInfo{
+		Path:         path,
+		Size:         info.Size(),
+		LastModified: info.ModTime(),
+		MimeType:     typ,
+		ETag:         fmt.Sprintf("%x-%x", info.ModTime().UnixNano(), info.Size()),
+		Visibility:   visibility(info.Mode()),
+	}, nil
+}
+
+// Update will overwrite a existing file locally,
+// it fails with adapter.ErrNotExist when the file is missing.
+func (a *Adapter) Update(path, content string, opts ...adapter.Option) error {
+	return a.write("update", path, strings.NewReader(content), os.O_TRUNC, opts...)
+}
+
+// Visibility will return the visibility of a file,
+// it's private unless the file is readable by others.
+func (a *Adapter) Visibility(path string) (string, error) {
+	root, name, err := a.open(path, false)
+	if err != nil {
+		return "", wrapError("visibility", path, err)
+	}
+
+	defer root.Close()
+
+	info, err := root.Stat(name)
+	if err != nil {
+		return "", wrapError("visibility", path, err)
+	}
+
+	return visibility(info.Mode()), nil
 }
 
 // Write will write a a new file locally.
-func (a *Adapter) Write(path, content string, args ...interface{}) error {
-	perm := uint32(0644)
-	if len(args) > 0 {
-		perm = args[0].(uint32)
+func (a *Adapter) Write(path, content string, opts ...adapter.Option) error {
+	return a.WriteStream(path, strings.NewReader(content), opts...)
+}
+
+// WriteNew will write a new file locally using O_EXCL,
+// it fails with adapter.ErrExist when the file exists.
+func (a *Adapter) WriteNew(path, content string, opts ...adapter.Option) error {
+	return a.write("writenew", path, strings.NewReader(content), os.O_CREATE|os.O_EXCL, opts...)
+}
+
+// WriteStream will write a new file locally from a reader.
+// The permissions default to 0644, or to 0600 for private visibility.
+func (a *Adapter) WriteStream(path string, r io.Reader, opts ...adapter.Option) error {
+	return a.write("write", path, r, os.O_CREATE|os.O_TRUNC, opts...)
+}
+
+// write opens the file with the flag in write only mode and copies the reader to it.
+func (a *Adapter) write(op, path string, r io.Reader, flag int, opts ...adapter.Option) error {
+	if err := a.ctx.Err(); err != nil {
+		return wrapError(op, path, err)
 	}
 
-	a.CreateDir(filepath.Dir(path))
+	cfg := adapter.NewConfig(opts...)
+	perm := fileMode(cfg, 0644, 0600, 0644)
 
-	if err := ioutil.WriteFile(a.appendPath(path), []byte(content), os.FileMode(perm)); err != nil {
-		return err
+	root, name, err := a.open(path, flag&os.O_CREATE != 0)
+	if err != nil {
+		return wrapError(op, path, err)
 	}
 
-	return nil
+	defer root.Close()
+
+	if flag&os.O_CREATE != 0 {
+		if err := mkdirAll(root, filepath.Dir(name), 0777); err != nil {
+			return wrapError(op, path, err)
+		}
+	}
+
+	if a.atomic {
+		return wrapError(op, path, a.writeAtomic(root, name, r, flag, cfg, perm))
+	}
+
+	file, err := root.OpenFile(name, os.O_WRONLY|flag, perm)
+	if err != nil {
+		return wrapError(op, path, err)
+	}
+
+	// The mode is only used when the file is created,
+	// so existing files are changed explicitly.
+	if cfg.Permissions != 0 || cfg.Visibility != "" {
+		if err := file.Chmod(perm); err != nil {
+			file.Close()
+			return wrapError(op, path, err)
+		}
+	}
+
+	if _, err := copyContext(a.ctx, file, r); err != nil {
+		file.Close()
+		return wrapError(op, path, err)
+	}
+
+	if err := a.sync(file); err != nil {
+		file.Close()
+		return wrapError(op, path, err)
+	}
+
+	if err := file.Close(); err != nil {
+		return wrapError(op, path, err)
+	}
+
+	return wrapError(op, path, a.syncDir(root, filepath.Dir(name)))
+}
+
+// fileMode returns the permissions from the config, falling back
+// on the mode for the visibility and then on the default mode.
+func fileMode(cfg *adapter.Config, public, private, def os.FileMode) os.FileMode {
+	switch {
+	case cfg.Permissions != 0:
+		return cfg.Permissions
+	case cfg.Visibility == adapter.VisibilityPublic:
+		return public
+	case cfg.Visibility == adapter.VisibilityPrivate:
+		return private
+	}
+
+	return def
+}
+
+// visibility returns the visibility for the permissions.
+func visibility(mode os.FileMode) string {
+	if mode.Perm()&0004 != 0 {
+		return adapter.VisibilityPublic
+	}
+
+	return adapter.VisibilityPrivate
+}
+
+// wrapError maps a native error onto the adapter errors
+// and wraps it in a *adapter.PathError.
+func wrapError(op, path string, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	// ENOTEMPTY is checked first since os.IsExist matches it as well.
+	switch {
+	case escapes(err):
+		err = adapter.ErrInvalidPath
+	case errors.Is(err, syscall.ENOTEMPTY):
+		err = adapter.ErrDirNotEmpty
+	case os.IsNotExist(err):
+		err = adapter.ErrNotExist
+	case os.IsExist(err):
+		err = adapter.ErrExist
+	case os.IsPermission(err):
+		err = adapter.ErrPermission
+	case errors.Is(err, syscall.EISDIR):
+		err = adapter.ErrIsDir
+	case errors.Is(err, syscall.ENOTDIR):
+		err = adapter.ErrNotDir
+	}
+
+	return &adapter.PathError{Op: op, Path: path, Adapter: "flylocal", Err: err}
 }
@@ -1,10 +1,19 @@
 package flylocal
 
 import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"strings"
+	"syscall"
 	"testing"
+	"time"
 
 	"github.com/frozzare/go-assert"
+	"github.com/frozzare/go-fly/adapter"
+	"github.com/frozzare/go-fly/flytest"
 )
 
 func TestDirectory(t *testing.T) {
@@ -57,3 +66,459 @@ func TestFileMimeType(t *testing.T) {
 	typ, err := fs.MimeType("test/hello.txt")
 	assert.Equal(t, "text/plain", typ)
 }
+
+func TestFileStream(t *testing.T) {
+	fs := NewAdapter("/tmp/flylocal")
+
+	err := fs.WriteStream("test/stream.txt", strings.NewReader("Hello, stream!"))
+	assert.Nil(t, err)
+
+	r, err := fs.ReadStream("test/stream.txt")
+	assert.Nil(t, err)
+
+	buf, err := ioutil.ReadAll(r)
+	assert.Nil(t, err)
+	assert.Nil(t, r.Close())
+	assert.Equal(t, "Hello, stream!", string(buf))
+
+	err = fs.Delete("test/stream.txt")
+	assert.Nil(t, err)
+}
+
+func TestListContents(t *testing.T) {
+	fs := NewAdapter("/tmp/flylocal")
+	assert.Nil(t, os.RemoveAll("/tmp/flylocal/list"))
+
+	assert.Nil(t, fs.Write("list/a.txt", "a"))
+	assert.Nil(t, fs.Write("list/sub/b.txt", "bb"))
+
+	entries, err := fs.ListContents("list", false)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(entries))
+	assert.Equal(t, "list/a.txt", entries[0].Path)
+	assert.Equal(t, int64(1), entries[0].Size)
+	assert.Equal(t, "list/sub", entries[1].Path)
+	assert.True(t, entries[1].IsDir())
+
+	entries, err = fs.ListContents("list", true)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(entries))
+	assert.Equal(t, "list/sub/b.txt", entries[2].Path)
+	assert.Equal(t, int64(2), entries[2].Size)
+}
+
+func TestStat(t *testing.T) {
+	fs := NewAdapter("/tmp/flylocal")
+
+	err := fs.Write("test/hello.txt", "Hello, world!")
+	assert.Nil(t, err)
+
+	info, err := fs.Stat("test/hello.txt")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(13), info.Size)
+	assert.Equal(t, "text/plain", info.MimeType)
+	assert.Equal(t, "public", info.Visibility)
+	assert.NotEmpty(t, info.ETag)
+	assert.False(t, info.LastModified.IsZero())
+
+	// Writes within the same second must change the ETag.
+	err = fs.Write("test/hello.txt", "Hello, there!")
+	assert.Nil(t, err)
+
+	changed, err := fs.Stat("test/hello.txt")
+	assert.Nil(t, err)
+	assert.NotEqual(t, info.ETag, changed.ETag)
+
+	_, err = fs.Stat("test")
+	assert.NotNil(t, err)
+}
+
+func TestErrors(t *testing.T) {
+	fs := NewAdapter("/tmp/flylocal")
+
+	_, err := fs.Read("test/missing.txt")
+	assert.True(t, errors.Is(err, adapter.ErrNotExist))
+
+	pathErr, ok := err.(*adapter.PathError)
+	assert.True(t, ok)
+	assert.Equal(t, "read", pathErr.Op)
+	assert.Equal(t, "flylocal", pathErr.Adapter)
+
+	err = fs.Delete("test/missing.txt")
+	assert.True(t, errors.Is(err, adapter.ErrNotExist))
+
+	err = fs.Write("test/hello.txt", "Hello, world!")
+	assert.Nil(t, err)
+
+	_, err = fs.Stat("test")
+	assert.True(t, errors.Is(err, adapter.ErrIsDir))
+
+	has, err := fs.Has("test/hello.txt/nested")
+	assert.False(t, has)
+	assert.Nil(t, err)
+
+	err = fs.Write("test/hello.txt/nested/hello.txt", "nested")
+	assert.True(t, errors.Is(err, adapter.ErrNotDir))
+
+	pathErr, ok = err.(*adapter.PathError)
+	assert.True(t, ok)
+	assert.Equal(t, "write", pathErr.Op)
+}
+
+func TestWriteOptions(t *testing.T) {
+	fs := NewAdapter("/tmp/flylocal")
+
+	err := fs.Write("test/private.txt", "secret", adapter.WithVisibility(adapter.VisibilityPrivate))
+	assert.Nil(t, err)
+
+	info, err := os.Stat("/tmp/flylocal/test/private.txt")
+	assert.Nil(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	stat, err := fs.Stat("test/private.txt")
+	assert.Nil(t, err)
+	assert.Equal(t, "private", stat.Visibility)
+
+	err = fs.Write("test/private.txt", "secret", adapter.WithPermissions(0640))
+	assert.Nil(t, err)
+
+	info, err = os.Stat("/tmp/flylocal/test/private.txt")
+	assert.Nil(t, err)
+	assert.Equal(t, os.FileMode(0640), info.Mode().Perm())
+
+	err = fs.CreateDir("test/private", adapter.WithVisibility(adapter.VisibilityPrivate))
+	assert.Nil(t, err)
+
+	info, err = os.Stat("/tmp/flylocal/test/private")
+	assert.Nil(t, err)
+	assert.Equal(t, os.FileMode(0700), info.Mode().Perm())
+
+	assert.Nil(t, fs.Delete("test/private.txt"))
+	assert.Nil(t, fs.DeleteDir("test/private"))
+}
+
+func TestContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	fs := NewAdapter("/tmp/flylocal").WithContext(ctx)
+
+	err := fs.Write("test/hello.txt", "Hello, world!")
+	assert.Nil(t, err)
+
+	r, err := fs.(adapter.Streamer).ReadStream("test/hello.txt")
+	assert.Nil(t, err)
+
+	cancel()
+
+	_, err = ioutil.ReadAll(r)
+	assert.True(t, errors.Is(err, context.Canceled))
+	assert.Nil(t, r.Close())
+
+	_, err = fs.Read("test/hello.txt")
+	assert.True(t, errors.Is(err, context.Canceled))
+
+	err = fs.Write("test/hello.txt", "Hello, world!")
+	assert.True(t, errors.Is(err, context.Canceled))
+}
+
+func TestDeleteDir(t *testing.T) {
+	fs := NewAdapter("/tmp/flylocal")
+
+	assert.Nil(t, fs.Write("tree/a.txt", "a"))
+	assert.Nil(t, fs.Write("tree/sub/b.txt", "b"))
+
+	err := fs.DeleteEmptyDir("tree")
+	assert.True(t, errors.Is(err, adapter.ErrDirNotEmpty))
+
+	err = fs.DeleteDir("tree/a.txt")
+	assert.True(t, errors.Is(err, adapter.ErrNotDir))
+
+	err = fs.DeleteDir("tree")
+	assert.Nil(t, err)
+
+	has, err := fs.HasDir("tree")
+	assert.False(t, has)
+	assert.Nil(t, err)
+
+	err = fs.DeleteDir("tree")
+	assert.True(t, errors.Is(err, adapter.ErrNotExist))
+
+	assert.Nil(t, fs.CreateDir("empty"))
+	assert.Nil(t, fs.DeleteEmptyDir("empty"))
+}
+
+func TestConditionalWrites(t *testing.T) {
+	fs := NewAdapter("/tmp/flylocal")
+	os.Remove("/tmp/flylocal/cond/new.txt")
+
+	err := fs.Update("cond/new.txt", "updated")
+	assert.True(t, errors.Is(err, adapter.ErrNotExist))
+
+	err = fs.WriteNew("cond/new.txt", "new")
+	assert.Nil(t, err)
+
+	err = fs.WriteNew("cond/new.txt", "new")
+	assert.True(t, errors.Is(err, adapter.ErrExist))
+
+	err = fs.Update("cond/new.txt", "updated")
+	assert.Nil(t, err)
+
+	content, err := fs.Read("cond/new.txt")
+	assert.Nil(t, err)
+	assert.Equal(t, "updated", content)
+}
+
+func TestCopy(t *testing.T) {
+	fs := NewAdapter("/tmp/flylocal")
+	modTime := time.Unix(1504641539, 0)
+
+	assert.Nil(t, fs.Write("copy/hello.sh", "echo hello", adapter.WithPermissions(0750)))
+	assert.Nil(t, os.Chtimes("/tmp/flylocal/copy/hello.sh", modTime, modTime))
+
+	err := fs.Copy("copy/hello.sh", "copy/missing/parent/hello.sh")
+	assert.Nil(t, err)
+
+	info, err := os.Stat("/tmp/flylocal/copy/missing/parent/hello.sh")
+	assert.Nil(t, err)
+	assert.Equal(t, os.FileMode(0750), info.Mode().Perm())
+	assert.True(t, modTime.Equal(info.ModTime()))
+
+	err = fs.Copy("copy/missing", "copy/dir")
+	assert.True(t, errors.Is(err, adapter.ErrIsDir))
+
+	assert.Nil(t, fs.DeleteDir("copy"))
+}
+
+func TestRename(t *testing.T) {
+	fs := NewAdapter("/tmp/flylocal")
+
+	assert.Nil(t, fs.Write("rename/hello.txt", "Hello, world!"))
+
+	err := fs.Rename("rename/hello.txt", "rename/missing/parent/hello.txt")
+	assert.Nil(t, err)
+
+	content, err := fs.Read("rename/missing/parent/hello.txt")
+	assert.Nil(t, err)
+	assert.Equal(t, "Hello, world!", content)
+
+	// Paths on different devices fail with EXDEV and are copied instead.
+	rename = func(root *os.Root, src, dst string) error {
+		return &os.LinkError{Op: "renameat", Old: src, New: dst, Err: syscall.EXDEV}
+	}
+	defer func() { rename = (*os.Root).Rename }()
+
+	err = fs.Rename("rename/missing/parent/hello.txt", "rename/device/hello.txt")
+	assert.Nil(t, err)
+
+	has, err := fs.Has("rename/missing/parent/hello.txt")
+	assert.False(t, has)
+	assert.Nil(t, err)
+
+	content, err = fs.Read("rename/device/hello.txt")
+	assert.Nil(t, err)
+	assert.Equal(t, "Hello, world!", content)
+
+	err = fs.Rename("rename/missing", "rename/dir")
+	assert.True(t, errors.Is(err, adapter.ErrIsDir))
+
+	assert.Nil(t, fs.DeleteDir("rename"))
+}
+
+// failingReader returns the content and then fails like a broken connection.
+type failingReader struct {
+	content string
+}
+
+func (r *failingReader) Read(p []byte) (int, error) {
+	if r.content == "" {
+		return 0, errors.New("connection reset")
+	}
+
+	n := copy(p, r.content)
+	r.content = r.content[n:]
+
+	return n, nil
+}
+
+func TestAtomicWrites(t *testing.T) {
+	root := t.TempDir()
+	stale := filepath.Join(root, tempPrefix+"stale")
+	fresh := filepath.Join(root, tempPrefix+"fresh")
+	staleTime := time.Now().Add(-2 * tempMaxAge)
+
+	assert.Nil(t, ioutil.WriteFile(stale, []byte("stale"), 0600))
+	assert.Nil(t, ioutil.WriteFile(fresh, []byte("fresh"), 0600))
+	assert.Nil(t, os.Chtimes(stale, staleTime, staleTime))
+
+	fs := NewAdapter(root, WithAtomicWrites(), WithDurability(DurabilityFileAndDir))
+
+	_, err := os.Stat(stale)
+	assert.True(t, os.IsNotExist(err))
+
+	_, err = os.Stat(fresh)
+	assert.Nil(t, err)
+	assert.Nil(t, os.Remove(fresh))
+
+	err = fs.Write("test/hello.txt", "Hello, world!", adapter.WithPermissions(0600))
+	assert.Nil(t, err)
+
+	err = fs.Write("test/hello.txt", "Bye")
+	assert.Nil(t, err)
+
+	info, err := os.Stat(filepath.Join(root, "test/hello.txt"))
+	assert.Nil(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	err = fs.WriteStream("test/hello.txt", &failingReader{content: "Half"})
+	assert.NotNil(t, err)
+
+	content, err := fs.Read("test/hello.txt")
+	assert.Nil(t, err)
+	assert.Equal(t, "Bye", content)
+
+	err = fs.WriteNew("test/hello.txt", "new")
+	assert.True(t, errors.Is(err, adapter.ErrExist))
+
+	err = fs.Update("test/missing.txt", "updated")
+	assert.True(t, errors.Is(err, adapter.ErrNotExist))
+
+	err = fs.Write("test/hello.txt/nested.txt", "nested")
+	assert.True(t, errors.Is(err, adapter.ErrNotDir))
+
+	infos, err := ioutil.ReadDir(filepath.Join(root, "test"))
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(infos))
+	assert.Equal(t, "hello.txt", infos[0].Name())
+}
+
+func TestRootConfinement(t *testing.T) {
+	root := t.TempDir()
+	outside := t.TempDir()
+
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(outside, "secret.txt"), []byte("secret"), 0644))
+	assert.Nil(t, os.Symlink(outside, filepath.Join(root, "outside")))
+	assert.Nil(t, os.Symlink(filepath.Join(outside, "secret.txt"), filepath.Join(root, "secret.txt")))
+
+	// Escapes are detected by the message of the os.Root error,
+	// so these fail when the message changes.
+	for _, policy := range []SymlinkPolicy{SymlinkFollow, SymlinkReject, SymlinkAsFile} {
+		fs := NewAdapter(root, WithSymlinkPolicy(policy))
+
+		expected := adapter.ErrInvalidPath
+		if policy == SymlinkReject {
+			expected = ErrSymlink
+		}
+
+		_, err := fs.Read("../" + filepath.Base(outside) + "/secret.txt")
+		assert.True(t, errors.Is(err, adapter.ErrInvalidPath), policy)
+
+		_, err = fs.Read("outside/secret.txt")
+		assert.True(t, errors.Is(err, expected), policy)
+
+		_, err = fs.Read("secret.txt")
+		assert.True(t, errors.Is(err, expected), policy)
+
+		err = fs.Write("outside/hello.txt", "Hello, world!")
+		assert.True(t, errors.Is(err, expected), policy)
+
+		_, err = os.Stat(filepath.Join(outside, "hello.txt"))
+		assert.True(t, os.IsNotExist(err))
+	}
+}
+
+func TestSymlinks(t *testing.T) {
+	root := t.TempDir()
+
+	assert.Nil(t, os.MkdirAll(filepath.Join(root, "dir"), 0755))
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(root, "dir/hello.txt"), []byte("Hello, world!"), 0644))
+	assert.Nil(t, os.Symlink("dir", filepath.Join(root, "link")))
+	assert.Nil(t, os.Symlink("dir/hello.txt", filepath.Join(root, "hello.txt")))
+
+	// SymlinkFollow
+	fs := NewAdapter(root)
+
+	content, err := fs.Read("link/hello.txt")
+	assert.Nil(t, err)
+	assert.Equal(t, "Hello, world!", content)
+
+	has, err := fs.HasDir("link")
+	assert.Nil(t, err)
+	assert.True(t, has)
+
+	entries, err := fs.ListContents("", true)
+	assert.Nil(t, err)
+	assert.Equal(t, 4, len(entries))
+	assert.Equal(t, "hello.txt", entries[2].Path)
+	assert.Equal(t, adapter.TypeFile, entries[2].Type)
+	assert.Equal(t, "link", entries[3].Path)
+	assert.Equal(t, adapter.TypeDir, entries[3].Type)
+
+	// SymlinkReject
+	fs = NewAdapter(root, WithSymlinkPolicy(SymlinkReject))
+
+	_, err = fs.Read("link/hello.txt")
+	assert.True(t, errors.Is(err, ErrSymlink))
+
+	err = fs.Write("link/new.txt", "new")
+	assert.True(t, errors.Is(err, ErrSymlink))
+
+	_, err = fs.Has("hello.txt")
+	assert.True(t, errors.Is(err, ErrSymlink))
+
+	entries, err = fs.ListContents("", true)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(entries))
+
+	content, err = fs.Read("dir/hello.txt")
+	assert.Nil(t, err)
+	assert.Equal(t, "Hello, world!", content)
+
+	// SymlinkAsFile
+	fs = NewAdapter(root, WithSymlinkPolicy(SymlinkAsFile))
+
+	has, err = fs.Has("link")
+	assert.Nil(t, err)
+	assert.True(t, has)
+
+	has, err = fs.HasDir("link")
+	assert.Nil(t, err)
+	assert.False(t, has)
+
+	entries, err = fs.ListContents("", false)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(entries))
+	assert.Equal(t, adapter.TypeFile, entries[2].Type)
+
+	assert.Nil(t, fs.Delete("link"))
+
+	_, err = os.Lstat(filepath.Join(root, "link"))
+	assert.True(t, os.IsNotExist(err))
+
+	_, err = os.Stat(filepath.Join(root, "dir/hello.txt"))
+	assert.Nil(t, err)
+}
+
+func TestSuite(t *testing.T) {
+	root := t.TempDir()
+
+	flytest.RunAdapterSuite(t, func() adapter.Adapter {
+		dir, err := ioutil.TempDir(root, "suite")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return NewAdapter(dir)
+	})
+}
+
+func TestAtomicSuite(t *testing.T) {
+	root := t.TempDir()
+
+	flytest.RunAdapterSuite(t, func() adapter.Adapter {
+		dir, err := ioutil.TempDir(root, "suite")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return NewAdapter(dir, WithAtomicWrites(), WithDurability(DurabilityFile))
+	})
+}
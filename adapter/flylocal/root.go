@@ -0,0 +1,172 @@
+package flylocal
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+)
+
+// ErrSymlink is returned for paths through a symlink with SymlinkReject.
+var ErrSymlink = errors.New("symlink not allowed")
+
+// SymlinkPolicy represents how the adapter handles symlinks inside its path.
+// Whatever the policy, symlinks are never resolved outside the path, since
+// every operation goes through a os.Root.
+type SymlinkPolicy int
+
+// Symlink policies.
+const (
+	// SymlinkFollow follows symlinks that resolve inside the path.
+	// Symlinked directories are listed but not walked into.
+	SymlinkFollow SymlinkPolicy = iota
+
+	// SymlinkReject fails with ErrSymlink for paths through a symlink
+	// and leaves symlinks out of listings.
+	SymlinkReject
+
+	// SymlinkAsFile doesn't follow symlinks when checking, listing or deleting
+	// paths, so every symlink is a file. Reading a symlink reads its target.
+	SymlinkAsFile
+)
+
+// WithSymlinkPolicy sets how symlinks are handled, the default is SymlinkFollow.
+func WithSymlinkPolicy(p SymlinkPolicy) Option {
+	return func(a *Adapter) {
+		a.symlinks = p
+	}
+}
+
+// open opens the adapter path as a os.Root, which confines every operation
+// to it with openat, and returns the name of the path inside the root.
+// The adapter path is created first when create is true.
+func (a *Adapter) open(path string, create bool) (*os.Root, string, error) {
+	if create {
+		if err := os.MkdirAll(a.path, 0777); err != nil {
+			return nil, "", err
+		}
+	}
+
+	root, err := os.OpenRoot(a.path)
+	if err != nil {
+		return nil, "", err
+	}
+
+	name, err := a.name(root, path)
+	if err != nil {
+		root.Close()
+		return nil, "", err
+	}
+
+	return root, name, nil
+}
+
+// name returns the name of the path inside the root. With SymlinkReject every
+// existing element of the path is checked, the root still confines symlinks
+// created after the check.
+func (a *Adapter) name(root *os.Root, path string) (string, error) {
+	name := filepath.Clean(filepath.FromSlash(strings.TrimLeft(path, "/")))
+
+	if a.symlinks != SymlinkReject || name == "." {
+		return name, nil
+	}
+
+	parts := strings.Split(name, string(filepath.Separator))
+	for i := range parts {
+		info, err := root.Lstat(filepath.Join(parts[:i+1]...))
+		if err != nil {
+			break
+		}
+
+		if info.Mode()&os.ModeSymlink != 0 {
+			return "", ErrSymlink
+		}
+	}
+
+	return name, nil
+}
+
+// mkdirAll creates the directory and its parents in the root. Unlike
+// os.MkdirAll it fails with EEXIST when a element is a file, so that's
+// reported as ENOTDIR.
+func mkdirAll(root *os.Root, dir string, perm os.FileMode) error {
+	err := root.MkdirAll(dir, perm)
+	if !os.IsExist(err) {
+		return err
+	}
+
+	if info, serr := root.Stat(dir); serr == nil && info.IsDir() {
+		return nil
+	}
+
+	return &os.PathError{Op: "mkdir", Path: dir, Err: syscall.ENOTDIR}
+}
+
+// escapes checks whether the error is from a path that resolves outside
+// a os.Root, os doesn't export the error so its message is compared.
+func escapes(err error) bool {
+	return strings.HasSuffix(err.Error(), "path escapes from parent")
+}
+
+// stat returns the file info of the name,
+// symlinks aren't followed with SymlinkAsFile.
+func (a *Adapter) stat(root *os.Root, name string) (os.FileInfo, error) {
+	if a.symlinks == SymlinkAsFile {
+		return root.Lstat(name)
+	}
+
+	return root.Stat(name)
+}
+
+// walk calls fn for the files and directories in the directory sorted by name,
+// sub directories are walked as well when recursive is true. Temporary files
+// of atomic writes in progress are left out.
+func (a *Adapter) walk(root *os.Root, dir string, recursive bool, fn func(string, os.FileInfo)) error {
+	if err := a.ctx.Err(); err != nil {
+		return err
+	}
+
+	d, err := root.Open(dir)
+	if err != nil {
+		return err
+	}
+
+	infos, err := d.Readdir(-1)
+	d.Close()
+
+	if err != nil {
+		return err
+	}
+
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Name() < infos[j].Name()
+	})
+
+	for _, info := range infos {
+		name := filepath.Join(dir, info.Name())
+		link := info.Mode()&os.ModeSymlink != 0
+
+		if isTemp(info.Name()) || link && a.symlinks == SymlinkReject {
+			continue
+		}
+
+		// Symlinks that don't resolve inside the root are listed as files.
+		if link && a.symlinks == SymlinkFollow {
+			if target, err := root.Stat(name); err == nil {
+				info = target
+			}
+		}
+
+		fn(name, info)
+
+		if recursive && info.IsDir() && !link {
+			if err := a.walk(root, name, true, fn); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,508 @@
+package flymemory
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"mime"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/frozzare/go-fly/adapter"
+)
+
+// file represents a file stored in memory.
+type file struct {
+	content []byte
+	modTime time.Time
+	config  adapter.Config
+}
+
+// Adapter represents a in-memory adapter, it's safe for concurrent use.
+type Adapter struct {
+	mu    sync.RWMutex
+	files map[string]*file
+	dirs  map[string]time.Time
+}
+
+// NewAdapter creates a new in-memory adapter.
+func NewAdapter() *Adapter {
+	return &Adapter{
+		files: map[string]*file{},
+		dirs:  map[string]time.Time{},
+	}
+}
+
+// clean returns the path without leading and trailing slashes.
+func clean(p string) string {
+	return strings.Trim(path.Clean("/"+p), "/")
+}
+
+// parents returns the parent directories of a path, closest last.
+func parents(p string) []string {
+	dirs := []string{}
+
+	for i := 0; i < len(p); i++ {
+		if p[i] == '/' {
+			dirs = append(dirs, p[:i])
+		}
+	}
+
+	return dirs
+}
+
+// isDir checks whether the path is a directory, the root always exists.
+func (a *Adapter) isDir(p string) bool {
+	_, ok := a.dirs[p]
+	return p == "" || ok
+}
+
+// mkdirAll creates the directory and its parents, it must be called with the lock held.
+func (a *Adapter) mkdirAll(p string, now time.Time) error {
+	for _, dir := range append(parents(p), p) {
+		if _, ok := a.files[dir]; ok {
+			return adapter.ErrNotDir
+		}
+
+		if !a.isDir(dir) {
+			a.dirs[dir] = now
+		}
+	}
+
+	return nil
+}
+
+// put stores a file, it must be called with the lock held.
+func (a *Adapter) put(p string, content []byte, cfg adapter.Config) error {
+	if a.isDir(p) {
+		return adapter.ErrIsDir
+	}
+
+	now := time.Now()
+
+	if dir := path.Dir(p); dir != "." {
+		if err := a.mkdirAll(dir, now); err != nil {
+			return err
+		}
+	}
+
+	a.files[p] = &file{content: content, modTime: now, config: cfg}
+
+	return nil
+}
+
+// Copy will copy a file to a new path in memory.
+func (a *Adapter) Copy(src, dst string) error {
+	src, dst = clean(src), clean(dst)
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	f, ok := a.files[src]
+	if !ok {
+		return wrapError("copy", src, a.missing(src))
+	}
+
+	return wrapError("copy", dst, a.put(dst, f.content, f.config))
+}
+
+// CreateDir will create a directory and its parents.
+func (a *Adapter) CreateDir(p string, opts ...adapter.Option) error {
+	p = clean(p)
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return wrapError("createdir", p, a.mkdirAll(p, time.Now()))
+}
+
+// Delete will delete a file in memory.
+func (a *Adapter) Delete(p string) error {
+	p = clean(p)
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if _, ok := a.files[p]; !ok {
+		return wrapError("delete", p, a.missing(p))
+	}
+
+	delete(a.files, p)
+
+	return nil
+}
+
+// DeleteDir will delete a directory and everything in it.
+func (a *Adapter) DeleteDir(p string) error {
+	p = clean(p)
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if err := a.checkDir(p); err != nil {
+		return wrapError("deletedir", p, err)
+	}
+
+	prefix := p + "/"
+
+	for name := range a.files {
+		if strings.HasPrefix(name, prefix) {
+			delete(a.files, name)
+		}
+	}
+
+	for name := range a.dirs {
+		if name == p || strings.HasPrefix(name, prefix) {
+			delete(a.dirs, name)
+		}
+	}
+
+	return nil
+}
+
+// DeleteEmptyDir will delete a directory only if it's empty.
+func (a *Adapter) DeleteEmptyDir(p string) error {
+	p = clean(p)
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if err := a.checkDir(p); err != nil {
+		return wrapError("deleteemptydir", p, err)
+	}
+
+	if len(a.children(p, false)) > 0 {
+		return wrapError("deleteemptydir", p, adapter.ErrDirNotEmpty)
+	}
+
+	delete(a.dirs, p)
+
+	return nil
+}
+
+// checkDir returns a error unless the path is a existing directory other than the root.
+func (a *Adapter) checkDir(p string) error {
+	switch {
+	case p == "":
+		return adapter.ErrInvalidPath
+	case a.files[p] != nil:
+		return adapter.ErrNotDir
+	case !a.isDir(p):
+		return adapter.ErrNotExist
+	}
+
+	return nil
+}
+
+// missing returns the error for a file that doesn't exist.
+func (a *Adapter) missing(p string) error {
+	if a.isDir(p) {
+		return adapter.ErrIsDir
+	}
+
+	return adapter.ErrNotExist
+}
+
+// Has will check whether a file exists.
+func (a *Adapter) Has(p string) (bool, error) {
+	p = clean(p)
+
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	_, ok := a.files[p]
+
+	return ok, nil
+}
+
+// HasDir will check whether a directory exists.
+func (a *Adapter) HasDir(p string) (bool, error) {
+	p = clean(p)
+
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	return a.isDir(p), nil
+}
+
+// ListContents will list the files and directories in a directory.
+func (a *Adapter) ListContents(dir string, recursive bool) ([]adapter.Entry, error) {
+	dir = clean(dir)
+
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	if a.files[dir] != nil {
+		return nil, wrapError("list", dir, adapter.ErrNotDir)
+	}
+
+	if !a.isDir(dir) {
+		return nil, wrapError("list", dir, adapter.ErrNotExist)
+	}
+
+	return a.children(dir, recursive), nil
+}
+
+// children returns the entries in a directory sorted by path,
+// it must be called with the lock held.
+func (a *Adapter) children(dir string, recursive bool) []adapter.Entry {
+	prefix := dir + "/"
+	if dir == "" {
+		prefix = ""
+	}
+
+	include := func(name string) bool {
+		if !strings.HasPrefix(name, prefix) || name == dir {
+			return false
+		}
+
+		return recursive || !strings.Contains(name[len(prefix):], "/")
+	}
+
+	entries := []adapter.Entry{}
+
+	for name, modTime := range a.dirs {
+		if include(name) {
+			entries = append(entries, adapter.Entry{
+				Path:         name,
+				Type:         adapter.TypeDir,
+				LastModified: modTime,
+			})
+		}
+	}
+
+	for name, f := range a.files {
+		if include(name) {
+			entries = append(entries, adapter.Entry{
+				Path:         name,
+				Type:         adapter.TypeFile,
+				Size:         int64(len(f.content)),
+				LastModified: f.modTime,
+			})
+		}
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Path < entries[j].Path
+	})
+
+	return entries
+}
+
+// MimeType will return the file mime type, either the content
+// type it was written with or the type of the extension.
+func (a *Adapter) MimeType(p string) (string, error) {
+	info, err := a.Stat(p)
+	if err != nil {
+		return "", err
+	}
+
+	return info.MimeType, nil
+}
+
+// Read will read a file in memory.
+func (a *Adapter) Read(p string) (string, error) {
+	p = clean(p)
+
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	f, ok := a.files[p]
+	if !ok {
+		return "", wrapError("read", p, a.missing(p))
+	}
+
+	return string(f.content), nil
+}
+
+// ReadAndDelete will read a file and delete it if any.
+func (a *Adapter) ReadAndDelete(p string) (string, error) {
+	p = clean(p)
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	f, ok := a.files[p]
+	if !ok {
+		return "", wrapError("readanddelete", p, a.missing(p))
+	}
+
+	delete(a.files, p)
+
+	return string(f.content), nil
+}
+
+// ReadStream will return a reader for a file in memory.
+func (a *Adapter) ReadStream(p string) (io.ReadCloser, error) {
+	p = clean(p)
+
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	f, ok := a.files[p]
+	if !ok {
+		return nil, wrapError("read", p, a.missing(p))
+	}
+
+	// The content is never modified in place, a write replaces it.
+	return ioutil.NopCloser(bytes.NewReader(f.content)), nil
+}
+
+// Rename will rename a file to a new path in memory.
+func (a *Adapter) Rename(src, dst string) error {
+	src, dst = clean(src), clean(dst)
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	f, ok := a.files[src]
+	if !ok {
+		return wrapError("rename", src, a.missing(src))
+	}
+
+	if err := a.put(dst, f.content, f.config); err != nil {
+		return wrapError("rename", dst, err)
+	}
+
+	if src != dst {
+		delete(a.files, src)
+	}
+
+	return nil
+}
+
+// SetVisibility will change the visibility of a file in memory.
+func (a *Adapter) SetVisibility(p, visibility string) error {
+	p = clean(p)
+
+	if visibility != adapter.VisibilityPublic && visibility != adapter.VisibilityPrivate {
+		return wrapError("setvisibility", p, adapter.ErrInvalidVisibility)
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	f, ok := a.files[p]
+	if !ok {
+		return wrapError("setvisibility", p, a.missing(p))
+	}
+
+	f.config.Visibility = visibility
+
+	return nil
+}
+
+// Stat will return the metadata of a file in memory.
+// The ETag is the MD5 checksum of the content.
+func (a *Adapter) Stat(p string) (adapter.FileInfo, error) {
+	p = clean(p)
+
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	f, ok := a.files[p]
+	if !ok {
+		return adapter.FileInfo{}, wrapError("stat", p, a.missing(p))
+	}
+
+	typ := f.config.ContentType
+	if typ == "" {
+		typ = strings.Split(mime.TypeByExtension(path.Ext(p)), ";")[0]
+	}
+
+	visibility := f.config.Visibility
+	if visibility == "" {
+		visibility = adapter.VisibilityPublic
+	}
+
+	sum := md5.Sum(f.content)
+
+	return adapter.FileInfo{
+		Path:         p,
+		Size:         int64(len(f.content)),
+		LastModified: f.modTime,
+		MimeType:     typ,
+		ETag:         hex.EncodeToString(sum[:]),
+		Visibility:   visibility,
+	}, nil
+}
+
+// Update will overwrite a existing file in memory,
+// it fails with adapter.ErrNotExist when the file is missing.
+func (a *Adapter) Update(p, content string, opts ...adapter.Option) error {
+	p = clean(p)
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if _, ok := a.files[p]; !ok {
+		return wrapError("update", p, a.missing(p))
+	}
+
+	return wrapError("update", p, a.put(p, []byte(content), *adapter.NewConfig(opts...)))
+}
+
+// Visibility will return the visibility of a file in memory,
+// files written without a visibility are public.
+func (a *Adapter) Visibility(p string) (string, error) {
+	info, err := a.Stat(p)
+	if err != nil {
+		return "", err
+	}
+
+	return info.Visibility, nil
+}
+
+// Write will write a file in memory.
+func (a *Adapter) Write(p, content string, opts ...adapter.Option) error {
+	p = clean(p)
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return wrapError("write", p, a.put(p, []byte(content), *adapter.NewConfig(opts...)))
+}
+
+// WriteNew will write a new file in memory,
+// it fails with adapter.ErrExist when the file exists.
+func (a *Adapter) WriteNew(p, content string, opts ...adapter.Option) error {
+	p = clean(p)
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if _, ok := a.files[p]; ok {
+		return wrapError("writenew", p, adapter.ErrExist)
+	}
+
+	return wrapError("writenew", p, a.put(p, []byte(content), *adapter.NewConfig(opts...)))
+}
+
+// WriteStream will write a file in memory from a reader.
+func (a *Adapter) WriteStream(p string, r io.Reader, opts ...adapter.Option) error {
+	p = clean(p)
+
+	content, err := ioutil.ReadAll(r)
+	if err != nil {
+		return wrapError("write", p, err)
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return wrapError("write", p, a.put(p, content, *adapter.NewConfig(opts...)))
+}
+
+// wrapError wraps the error in a *adapter.PathError.
+func wrapError(op, path string, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	return &adapter.PathError{Op: op, Path: path, Adapter: "flymemory", Err: err}
+}
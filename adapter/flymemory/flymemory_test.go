@@ -0,0 +1,194 @@
+package flymemory
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"testing/fstest"
+
+	"github.com/frozzare/go-assert"
+	"github.com/frozzare/go-fly"
+	"github.com/frozzare/go-fly/adapter"
+	"github.com/frozzare/go-fly/flytest"
+)
+
+func TestDirectory(t *testing.T) {
+	fs := NewAdapter()
+
+	err := fs.CreateDir("test/folder")
+	assert.Nil(t, err)
+
+	has, err := fs.HasDir("test/folder")
+	assert.True(t, has)
+	assert.Nil(t, err)
+
+	err = fs.DeleteDir("test/folder")
+	assert.Nil(t, err)
+
+	has, err = fs.HasDir("test/folder")
+	assert.False(t, has)
+	assert.Nil(t, err)
+
+	has, err = fs.HasDir("test")
+	assert.True(t, has)
+	assert.Nil(t, err)
+}
+
+func TestFile(t *testing.T) {
+	fs := NewAdapter()
+
+	err := fs.Write("test/hello.txt", "Hello, world!")
+	assert.Nil(t, err)
+
+	err = fs.Copy("test/hello.txt", "test/hello-copy.txt")
+	assert.Nil(t, err)
+
+	has, err := fs.Has("test/hello.txt")
+	assert.True(t, has)
+	assert.Nil(t, err)
+
+	content, err := fs.ReadAndDelete("test/hello-copy.txt")
+	assert.Nil(t, err)
+	assert.Equal(t, "Hello, world!", strings.TrimSpace(content))
+
+	has, err = fs.Has("test/hello-copy.txt")
+	assert.False(t, has)
+	assert.Nil(t, err)
+
+	err = fs.Rename("test/hello.txt", "moved/hello.txt")
+	assert.Nil(t, err)
+
+	has, err = fs.HasDir("moved")
+	assert.True(t, has)
+	assert.Nil(t, err)
+
+	_, err = fs.Read("test/hello.txt")
+	assert.True(t, errors.Is(err, adapter.ErrNotExist))
+
+	err = fs.Write("moved/hello.txt/nested.txt", "nested")
+	assert.True(t, errors.Is(err, adapter.ErrNotDir))
+
+	err = fs.Write("moved", "dir")
+	assert.True(t, errors.Is(err, adapter.ErrIsDir))
+}
+
+func TestFileMimeType(t *testing.T) {
+	fs := NewAdapter()
+
+	err := fs.Write("test/hello.txt", "Hello, world!")
+	assert.Nil(t, err)
+
+	typ, err := fs.MimeType("test/hello.txt")
+	assert.Nil(t, err)
+	assert.Equal(t, "text/plain", typ)
+
+	err = fs.Write("test/report", "%PDF", adapter.WithContentType("application/pdf"))
+	assert.Nil(t, err)
+
+	typ, err = fs.MimeType("test/report")
+	assert.Nil(t, err)
+	assert.Equal(t, "application/pdf", typ)
+}
+
+func TestStat(t *testing.T) {
+	fs := NewAdapter()
+
+	err := fs.Write("test/hello.txt", "Hello, world!", adapter.WithVisibility(adapter.VisibilityPrivate))
+	assert.Nil(t, err)
+
+	info, err := fs.Stat("test/hello.txt")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(13), info.Size)
+	assert.Equal(t, "6cd3556deb0da54bca060b4c39479839", info.ETag)
+	assert.Equal(t, adapter.VisibilityPrivate, info.Visibility)
+	assert.False(t, info.LastModified.IsZero())
+
+	_, err = fs.Stat("test")
+	assert.True(t, errors.Is(err, adapter.ErrIsDir))
+}
+
+func TestListContents(t *testing.T) {
+	fs := NewAdapter()
+
+	assert.Nil(t, fs.Write("list/a.txt", "a"))
+	assert.Nil(t, fs.Write("list/sub/b.txt", "bb"))
+	assert.Nil(t, fs.CreateDir("list/empty"))
+
+	entries, err := fs.ListContents("list", false)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(entries))
+	assert.Equal(t, "list/a.txt", entries[0].Path)
+	assert.Equal(t, "list/empty", entries[1].Path)
+	assert.True(t, entries[1].IsDir())
+	assert.Equal(t, "list/sub", entries[2].Path)
+
+	entries, err = fs.ListContents("", true)
+	assert.Nil(t, err)
+	assert.Equal(t, 5, len(entries))
+	assert.Equal(t, "list/sub/b.txt", entries[4].Path)
+	assert.Equal(t, int64(2), entries[4].Size)
+
+	err = fs.DeleteEmptyDir("list")
+	assert.True(t, errors.Is(err, adapter.ErrDirNotEmpty))
+
+	assert.Nil(t, fs.DeleteEmptyDir("list/empty"))
+	assert.Nil(t, fs.DeleteDir("list"))
+
+	entries, err = fs.ListContents("", true)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(entries))
+}
+
+func TestConditionalWrites(t *testing.T) {
+	fs := NewAdapter()
+
+	err := fs.Update("test/new.txt", "updated")
+	assert.True(t, errors.Is(err, adapter.ErrNotExist))
+
+	assert.Nil(t, fs.WriteNew("test/new.txt", "new"))
+
+	err = fs.WriteNew("test/new.txt", "new")
+	assert.True(t, errors.Is(err, adapter.ErrExist))
+
+	assert.Nil(t, fs.Update("test/new.txt", "updated"))
+}
+
+func TestConcurrency(t *testing.T) {
+	fs := NewAdapter()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			p := fmt.Sprintf("test/%d/file.txt", i%5)
+			fs.Write(p, "content")
+			fs.Read(p)
+			fs.ListContents("test", true)
+			fs.Rename(p, p+".bak")
+		}(i)
+	}
+	wg.Wait()
+
+	entries, err := fs.ListContents("test", false)
+	assert.Nil(t, err)
+	assert.Equal(t, 5, len(entries))
+}
+
+func TestFS(t *testing.T) {
+	fs := fly.NewFly(NewAdapter())
+
+	assert.Nil(t, fs.Write("index.html", "<h1>Fly</h1>"))
+	assert.Nil(t, fs.Write("static/css/app.css", "body {}"))
+
+	err := fstest.TestFS(fs.FS(), "index.html", "static/css/app.css")
+	assert.Nil(t, err)
+}
+
+func TestSuite(t *testing.T) {
+	flytest.RunAdapterSuite(t, func() adapter.Adapter {
+		return NewAdapter()
+	})
+}
@@ -0,0 +1,295 @@
+package flys3
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go/aws"
+	awsclient "github.com/aws/aws-sdk-go/aws/client"
+	"github.com/aws/aws-sdk-go/aws/defaults"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/aws/aws-sdk-go/service/kms/kmsiface"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3crypto"
+	"github.com/frozzare/go-fly/adapter"
+)
+
+// AESGCMWrap is the wrap algorithm of AESKeyWrap.
+const AESGCMWrap = "AES/GCM"
+
+// gcmTagSize is the size of the tag AES-GCM appends to the encrypted content.
+const gcmTagSize = 16
+
+// unencryptedLength is the metadata key of the size of a encrypted file.
+const unencryptedLength = "X-Amz-Unencrypted-Content-Length"
+
+// KeyWrap represents how the data key of each client-side encrypted
+// file is wrapped with a master key, e.g. a AWS KMS key.
+type KeyWrap interface {
+	s3crypto.CipherDataGenerator
+
+	// Algorithm returns the wrap algorithm, which is stored in the
+	// envelope of the file to find the key wrap of its data key.
+	Algorithm() string
+
+	// Decrypter returns the decrypter of the data key in the envelope.
+	Decrypter(env s3crypto.Envelope) (s3crypto.CipherDataDecrypter, error)
+}
+
+// kmsKeyWrap represents a key wrap with a AWS KMS key.
+type kmsKeyWrap struct {
+	s3crypto.CipherDataGenerator
+	kms kmsiface.KMSAPI
+}
+
+// KMSKeyWrap returns a key wrap that generates the data keys with the AWS KMS
+// key, it's compatible with the key wrap of the other AWS SDKs.
+func KMSKeyWrap(client kmsiface.KMSAPI, keyID string) KeyWrap {
+	return &kmsKeyWrap{
+		CipherDataGenerator: s3crypto.NewKMSKeyGenerator(client, keyID),
+		kms:                 client,
+	}
+}
+
+// Algorithm returns the wrap algorithm.
+func (w *kmsKeyWrap) Algorithm() string {
+	return s3crypto.KMSWrap
+}
+
+// Decrypter returns the decrypter of the data key, which is decrypted with the
+// material description as encryption context like it was generated with.
+func (w *kmsKeyWrap) Decrypter(env s3crypto.Envelope) (s3crypto.CipherDataDecrypter, error) {
+	context := map[string]*string{}
+	if err := json.Unmarshal([]byte(env.MatDesc), &context); err != nil {
+		return nil, err
+	}
+
+	return &kmsDecrypter{kms: w.kms, context: context}, nil
+}
+
+// kmsDecrypter decrypts a data key with AWS KMS.
+type kmsDecrypter struct {
+	kms     kmsiface.KMSAPI
+	context map[string]*string
+}
+
+// DecryptKey decrypts the data key.
+func (d *kmsDecrypter) DecryptKey(key []byte) ([]byte, error) {
+	res, err := d.kms.Decrypt(&kms.DecryptInput{
+		CiphertextBlob:    key,
+		EncryptionContext: d.context,
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return res.Plaintext, nil
+}
+
+// aesKeyWrap represents a key wrap with a local master key.
+type aesKeyWrap struct {
+	key []byte
+}
+
+// AESKeyWrap returns a key wrap that encrypts the data keys with the
+// 256-bit master key using AES-GCM, without a key management service.
+func AESKeyWrap(key []byte) KeyWrap {
+	return &aesKeyWrap{key: key}
+}
+
+// Algorithm returns the wrap algorithm.
+func (w *aesKeyWrap) Algorithm() string {
+	return AESGCMWrap
+}
+
+// Decrypter returns the key wrap, which decrypts every data key.
+func (w *aesKeyWrap) Decrypter(env s3crypto.Envelope) (s3crypto.CipherDataDecrypter, error) {
+	return w, nil
+}
+
+// GenerateCipherData generates a random data key and IV,
+// the data key is encrypted with the master key.
+func (w *aesKeyWrap) GenerateCipherData(keySize, ivSize int) (s3crypto.CipherData, error) {
+	key, iv := make([]byte, keySize), make([]byte, ivSize)
+
+	if _, err := rand.Read(key); err != nil {
+		return s3crypto.CipherData{}, err
+	}
+
+	if _, err := rand.Read(iv); err != nil {
+		return s3crypto.CipherData{}, err
+	}
+
+	gcm, err := w.gcm()
+	if err != nil {
+		return s3crypto.CipherData{}, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return s3crypto.CipherData{}, err
+	}
+
+	return s3crypto.CipherData{
+		Key:                 key,
+		IV:                  iv,
+		WrapAlgorithm:       AESGCMWrap,
+		MaterialDescription: s3crypto.MaterialDescription{},
+		EncryptedKey:        gcm.Seal(nonce, nonce, key, nil),
+	}, nil
+}
+
+// DecryptKey decrypts the data key with the master key.
+func (w *aesKeyWrap) DecryptKey(key []byte) ([]byte, error) {
+	gcm, err := w.gcm()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(key) < gcm.NonceSize() {
+		return nil, errors.New("encrypted data key is too short")
+	}
+
+	return gcm.Open(nil, key[:gcm.NonceSize()], key[gcm.NonceSize():], nil)
+}
+
+// gcm returns the AES-GCM cipher of the master key.
+func (w *aesKeyWrap) gcm() (cipher.AEAD, error) {
+	block, err := aes.NewCipher(w.key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// WithClientSideEncryption encrypts files with AES-GCM before they're written
+// to AWS S3 and decrypts them when they're read, with the s3crypto package.
+// Each file has its own data key, which is wrapped by the key wrap and stored
+// with the rest of the envelope in the metadata of the file.
+//
+// AES-GCM encrypts a file at once, so files are written with a single PutObject
+// request and ranged reads decrypt the file from the start. Files that aren't
+// encrypted can't be read and the sizes of listed files assume they are.
+// Temporary URLs and POST policies aren't supported since they would
+// bypass the encryption.
+func WithClientSideEncryption(wrap KeyWrap) Option {
+	return func(a *Adapter) {
+		a.encrypter = &s3crypto.EncryptionClient{
+			S3Client:             a.s3,
+			ContentCipherBuilder: s3crypto.AESGCMContentCipherBuilder(wrap),
+			SaveStrategy:         s3crypto.HeaderV2SaveStrategy{},
+			MinFileSize:          s3crypto.DefaultMinFileSize,
+		}
+
+		a.decrypter = s3crypto.NewDecryptionClient(provider{}, func(c *s3crypto.DecryptionClient) {
+			c.S3Client = a.s3
+			c.LoadStrategy = s3crypto.HeaderV2LoadStrategy{}
+			c.WrapRegistry = map[string]s3crypto.WrapEntry{
+				wrap.Algorithm(): wrap.Decrypter,
+			}
+		})
+	}
+}
+
+// provider is the ConfigProvider of the s3crypto decryption client,
+// which only uses it to create clients that the adapter replaces.
+type provider struct{}
+
+// ClientConfig returns the default client config.
+func (provider) ClientConfig(service string, cfgs ...*aws.Config) awsclient.Config {
+	return awsclient.Config{Config: defaults.Config().Copy(cfgs...), Handlers: defaults.Handlers()}
+}
+
+// putObject uploads the file, it's encrypted when client-side encryption is enabled.
+func (a *Adapter) putObject(input *s3.PutObjectInput, reqOpts ...request.Option) (*s3.PutObjectOutput, error) {
+	if a.encrypter != nil {
+		return a.encrypter.PutObjectWithContext(a.ctx, input, reqOpts...)
+	}
+
+	return a.s3.PutObjectWithContext(a.ctx, input, reqOpts...)
+}
+
+// getObject downloads the file, it's decrypted when client-side encryption is enabled.
+func (a *Adapter) getObject(input *s3.GetObjectInput) (*s3.GetObjectOutput, error) {
+	if a.decrypter != nil {
+		return a.decrypter.GetObjectWithContext(a.ctx, input)
+	}
+
+	return a.s3.GetObjectWithContext(a.ctx, input)
+}
+
+// seekable returns the reader as a io.ReadSeeker, readers that
+// can't seek are buffered since the encryption client must seek.
+func seekable(r io.Reader) (io.ReadSeeker, error) {
+	if body, ok := r.(io.ReadSeeker); ok {
+		return body, nil
+	}
+
+	buf, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return bytes.NewReader(buf), nil
+}
+
+// readRange reads the range of a client-side encrypted file, which is
+// decrypted from the start since AES-GCM can't decrypt a part of it.
+func (a *Adapter) readRange(path string, offset, length int64) (io.ReadCloser, error) {
+	rc, err := a.ReadStream(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := io.CopyN(ioutil.Discard, rc, offset); err != nil && err != io.EOF {
+		rc.Close()
+		return nil, wrapError("readrange", path, err)
+	}
+
+	if length < 0 {
+		return rc, nil
+	}
+
+	return &struct {
+		io.Reader
+		io.Closer
+	}{io.LimitReader(rc, length), rc}, nil
+}
+
+// plainSize returns the size of the file before it was encrypted,
+// from its metadata or else assuming it's encrypted with AES-GCM.
+func (a *Adapter) plainSize(size int64, metadata map[string]*string) int64 {
+	if a.decrypter == nil {
+		return size
+	}
+
+	if n, err := strconv.ParseInt(aws.StringValue(metadata[unencryptedLength]), 10, 64); err == nil {
+		return n
+	}
+
+	if size < gcmTagSize {
+		return 0
+	}
+
+	return size - gcmTagSize
+}
+
+// errEncrypted returns adapter.ErrNotSupported for operations that
+// would bypass client-side encryption.
+func (a *Adapter) errEncrypted() error {
+	if a.encrypter != nil {
+		return adapter.ErrNotSupported
+	}
+
+	return nil
+}
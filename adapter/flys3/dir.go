@@ -0,0 +1,96 @@
+package flys3
+
+import (
+	"path"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/frozzare/go-fly/adapter"
+)
+
+// MarkerPolicy represents when the adapter writes directory markers, the empty
+// keys with a trailing slash that make directories exist without files in them.
+// Whatever the policy, a directory exists while there's any key under it.
+type MarkerPolicy int
+
+// Marker policies.
+const (
+	// MarkersAlways writes a marker for every created directory
+	// and keeps it when files are written to the directory.
+	MarkersAlways MarkerPolicy = iota
+
+	// MarkersNever doesn't write markers, so CreateDir does nothing
+	// and directories only exist while there are files in them.
+	MarkersNever
+
+	// MarkersEmptyDirs only keeps markers for empty directories. The markers
+	// of the parent directories are deleted when a file is written and the
+	// marker of the parent directory is written when its last file is deleted,
+	// which takes a extra request for every write and delete.
+	MarkersEmptyDirs
+)
+
+// WithMarkerPolicy sets when directory markers are written, the default is MarkersAlways.
+func WithMarkerPolicy(p MarkerPolicy) Option {
+	return func(a *Adapter) {
+		a.markers = p
+	}
+}
+
+// parentDirs returns the parent directories of the path, closest first.
+func parentDirs(p string) []string {
+	dirs := []string{}
+
+	for dir := path.Dir(strings.Trim(p, "/")); dir != "." && dir != "/"; dir = path.Dir(dir) {
+		dirs = append(dirs, dir)
+	}
+
+	return dirs
+}
+
+// written deletes the markers of the parent directories of
+// a written file or directory with MarkersEmptyDirs.
+func (a *Adapter) written(path string) error {
+	if a.markers != MarkersEmptyDirs {
+		return nil
+	}
+
+	objects := []*s3.ObjectIdentifier{}
+	for _, dir := range parentDirs(path) {
+		objects = append(objects, &s3.ObjectIdentifier{Key: aws.String(a.dirKey(dir))})
+	}
+
+	return a.deleteObjects(objects)
+}
+
+// deleted writes the marker of the parent directory of a deleted
+// file or directory with MarkersEmptyDirs, when it's empty.
+func (a *Adapter) deleted(path string) error {
+	dirs := parentDirs(path)
+	if a.markers != MarkersEmptyDirs || len(dirs) == 0 {
+		return nil
+	}
+
+	empty, err := a.isEmpty(dirs[0])
+	if err != nil || !empty {
+		return err
+	}
+
+	return a.put(dirs[0]+"/", strings.NewReader(""), adapter.NewConfig())
+}
+
+// isEmpty checks whether there are no keys under the directory.
+func (a *Adapter) isEmpty(dir string) (bool, error) {
+	res, err := a.s3.ListObjectsV2WithContext(a.ctx, &s3.ListObjectsV2Input{
+		Bucket:  aws.String(a.bucket),
+		Prefix:  aws.String(a.dirKey(dir)),
+		MaxKeys: aws.Int64(1),
+	})
+
+	if err != nil {
+		return false, err
+	}
+
+	return len(res.Contents) == 0, nil
+}
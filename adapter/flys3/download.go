@@ -0,0 +1,95 @@
+package flys3
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/frozzare/go-fly/adapter"
+)
+
+// Download will download a file on AWS S3 into the writer with ranged requests
+// of the part size, which are sent in parallel up to the concurrency.
+// It returns the number of bytes downloaded.
+func (a *Adapter) Download(path string, w io.WriterAt) (int64, error) {
+	if a.decrypter != nil {
+		rc, err := a.ReadStream(path)
+		if err != nil {
+			return 0, err
+		}
+
+		defer rc.Close()
+
+		n, err := io.Copy(io.NewOffsetWriter(w, 0), rc)
+		return n, wrapError("download", path, err)
+	}
+
+	downloader := s3manager.NewDownloaderWithClient(a.s3, func(d *s3manager.Downloader) {
+		d.PartSize = a.partSize
+		d.Concurrency = a.concurrency
+	})
+
+	n, err := downloader.DownloadWithContext(a.ctx, w, a.getObjectInput(path))
+
+	// The first part of a empty file is out of range.
+	if invalidRange(err) {
+		return 0, nil
+	}
+
+	return n, wrapError("download", path, err)
+}
+
+// ReadRange will read length bytes of a file on AWS S3 from the offset with a
+// HTTP Range request, a negative length reads to the end of the file.
+func (a *Adapter) ReadRange(path string, offset, length int64) (io.ReadCloser, error) {
+	if offset < 0 {
+		return nil, wrapError("readrange", path, adapter.ErrInvalidRange)
+	}
+
+	// A range can't be empty, so only check that the file exists.
+	if length == 0 {
+		_, err := a.s3.HeadObjectWithContext(a.ctx, a.headObjectInput(path))
+
+		if err != nil {
+			return nil, wrapError("readrange", path, err)
+		}
+
+		return ioutil.NopCloser(strings.NewReader("")), nil
+	}
+
+	if a.decrypter != nil {
+		return a.readRange(path, offset, length)
+	}
+
+	rng := fmt.Sprintf("bytes=%d-", offset)
+	if length > 0 {
+		rng += fmt.Sprint(offset + length - 1)
+	}
+
+	input := a.getObjectInput(path)
+	input.Range = aws.String(rng)
+
+	res, err := a.s3.GetObjectWithContext(a.ctx, input)
+
+	// The offset is at or past the end of the file.
+	if invalidRange(err) {
+		return ioutil.NopCloser(strings.NewReader("")), nil
+	}
+
+	if err != nil {
+		return nil, wrapError("readrange", path, err)
+	}
+
+	return res.Body, nil
+}
+
+// invalidRange checks whether AWS S3 rejected the range of a request
+// since it starts at or past the end of the file.
+func invalidRange(err error) bool {
+	aerr, ok := err.(awserr.Error)
+	return ok && aerr.Code() == "InvalidRange"
+}
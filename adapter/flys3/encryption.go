@@ -0,0 +1,122 @@
+package flys3
+
+import (
+	"encoding/base64"
+	"encoding/json"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/frozzare/go-fly/adapter"
+)
+
+// Server-side encryption algorithms.
+const (
+	EncryptionAES256 = s3.ServerSideEncryptionAes256
+	EncryptionKMS    = s3.ServerSideEncryptionAwsKms
+)
+
+// SSES3 returns the server-side encryption with keys managed by AWS S3.
+func SSES3() adapter.Encryption {
+	return adapter.Encryption{Algorithm: EncryptionAES256}
+}
+
+// SSEKMS returns the server-side encryption with a AWS KMS key, a empty key id
+// uses the AWS managed key. The context is logged by AWS CloudTrail.
+func SSEKMS(keyID string, context map[string]string) adapter.Encryption {
+	return adapter.Encryption{Algorithm: EncryptionKMS, KeyID: keyID, Context: context}
+}
+
+// SSEC returns the server-side encryption with a 256-bit key provided by the
+// client. AWS S3 doesn't keep the key, so every read of the file needs it.
+func SSEC(key []byte) adapter.Encryption {
+	return adapter.Encryption{Algorithm: EncryptionAES256, CustomerKey: key}
+}
+
+// WithDefaultEncryption sets the server-side encryption of files written
+// without the adapter.WithEncryption option and of copied files. A customer
+// key is sent with every request that reads a file as well, so the adapter
+// can only read files encrypted with that key.
+func WithDefaultEncryption(e adapter.Encryption) Option {
+	return func(a *Adapter) {
+		a.encryption = &e
+	}
+}
+
+// sse represents the server-side encryption fields of a request.
+type sse struct {
+	algorithm         *string
+	kmsKeyID          *string
+	customerAlgorithm *string
+	customerKey       *string
+}
+
+// sse returns the fields for the encryption, the default
+// encryption of the adapter is used when it's nil.
+func (a *Adapter) sse(e *adapter.Encryption) sse {
+	if e == nil {
+		e = a.encryption
+	}
+
+	switch {
+	case e == nil:
+		return sse{}
+	case len(e.CustomerKey) > 0:
+		return sse{
+			customerAlgorithm: aws.String(algorithm(e)),
+			customerKey:       aws.String(string(e.CustomerKey)),
+		}
+	case e.KeyID != "":
+		return sse{algorithm: aws.String(algorithm(e)), kmsKeyID: aws.String(e.KeyID)}
+	}
+
+	return sse{algorithm: aws.String(algorithm(e))}
+}
+
+// algorithm returns the algorithm of the encryption, AES256 when it's empty.
+func algorithm(e *adapter.Encryption) string {
+	if e.Algorithm == "" {
+		return EncryptionAES256
+	}
+
+	return e.Algorithm
+}
+
+// customerKey returns the customer key fields of the default
+// encryption, which are needed to read a file encrypted with it.
+func (a *Adapter) customerKey() (*string, *string) {
+	e := a.sse(nil)
+	return e.customerAlgorithm, e.customerKey
+}
+
+// encryptionContext returns the request options that set the AWS KMS encryption
+// context of the encryption, which has no field in this version of the SDK.
+// It's only set on the requests that create a file.
+func (a *Adapter) encryptionContext(e *adapter.Encryption) []request.Option {
+	value := a.encodedContext(e)
+	if value == "" {
+		return nil
+	}
+
+	return []request.Option{func(r *request.Request) {
+		switch r.Operation.Name {
+		case "PutObject", "CreateMultipartUpload", "CopyObject":
+			r.HTTPRequest.Header.Set("X-Amz-Server-Side-Encryption-Context", value)
+		}
+	}}
+}
+
+// encodedContext returns the AWS KMS encryption context of the encryption
+// as base64-encoded JSON, or a empty string when there's none.
+func (a *Adapter) encodedContext(e *adapter.Encryption) string {
+	if e == nil {
+		e = a.encryption
+	}
+
+	if e == nil || len(e.Context) == 0 || len(e.CustomerKey) > 0 {
+		return ""
+	}
+
+	data, _ := json.Marshal(e.Context)
+	return base64.StdEncoding.EncodeToString(data)
+}
@@ -1,114 +1,443 @@
 package flys3
 
 import (
-	"bytes"
+	"context"
 	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
 	"mime"
 	"path/filepath"
+	"sort"
 	"strings"
 
-	"io/ioutil"
-
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3crypto"
 	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/frozzare/go-fly/adapter"
 )
 
+// maxDeleteObjects is the most keys a DeleteObjects request accepts.
+const maxDeleteObjects = 1000
+
+// allUsersURI is the grantee URI AWS S3 uses for anonymous access.
+const allUsersURI = "http://acs.amazonaws.com/groups/global/AllUsers"
+
+// KeyError records why AWS S3 failed to delete a key.
+type KeyError struct {
+	Key     string
+	Code    string
+	Message string
+}
+
+// DeleteError records the keys AWS S3 failed to delete in a batch.
+type DeleteError struct {
+	Errors []KeyError
+}
+
+// Error returns the error message.
+func (e *DeleteError) Error() string {
+	first := e.Errors[0]
+	return fmt.Sprintf("failed to delete %d keys, %s: %s: %s", len(e.Errors), first.Key, first.Code, first.Message)
+}
+
+// Option represents a function that configures the adapter.
+type Option func(*Adapter)
+
+// WithPartSize sets the size of the parts of multipart uploads and downloads,
+// files larger than a part are uploaded in parts. The default and minimum is 5 MB.
+func WithPartSize(size int64) Option {
+	return func(a *Adapter) {
+		if size < s3manager.MinUploadPartSize {
+			size = s3manager.MinUploadPartSize
+		}
+
+		a.partSize = size
+	}
+}
+
+// WithConcurrency sets how many parts of a multipart upload or
+// download are transferred in parallel, the default is 5.
+func WithConcurrency(n int) Option {
+	return func(a *Adapter) {
+		if n > 0 {
+			a.concurrency = n
+		}
+	}
+}
+
 // Adapter represents a AWS S3 adapter.
 type Adapter struct {
-	bucket string
-	s3     s3iface.S3API
+	bucket      string
+	concurrency int
+	ctx         context.Context
+	markers     MarkerPolicy
+	decrypter   *s3crypto.DecryptionClient
+	encrypter   *s3crypto.EncryptionClient
+	encryption  *adapter.Encryption
+	keys        KeyPolicy
+	partSize    int64
+	prefix      string
+	s3          s3iface.S3API
 }
 
 // NewAdapter creates a new AWS S3 adapter.
-func NewAdapter(client s3iface.S3API, bucket string) *Adapter {
-	return &Adapter{bucket, client}
+func NewAdapter(client s3iface.S3API, bucket string, opts ...Option) *Adapter {
+	a := &Adapter{
+		bucket:      bucket,
+		concurrency: s3manager.DefaultUploadConcurrency,
+		ctx:         context.Background(),
+		partSize:    s3manager.DefaultUploadPartSize,
+		s3:          client,
+	}
+
+	for _, opt := range opts {
+		opt(a)
+	}
+
+	return a
+}
+
+// WithContext returns a copy of the adapter that uses the context for every AWS S3 request.
+func (a *Adapter) WithContext(ctx context.Context) adapter.Adapter {
+	a2 := *a
+	a2.ctx = ctx
+	return &a2
+}
+
+// Emulated returns the optional interfaces that the adapter implements with
+// other requests, Rename copies and deletes the file.
+func (a *Adapter) Emulated() []string {
+	return []string{"Renamer"}
 }
 
 // Copy will copy a file to a new path on AWS S3.
+// The copy is encrypted with the default encryption.
 func (a *Adapter) Copy(src, dst string) error {
-	_, err := a.s3.CopyObject(&s3.CopyObjectInput{
-		Bucket:     aws.String(a.bucket),
-		Key:        aws.String(dst),
-		CopySource: aws.String(a.bucket + "/" + src),
-	})
+	if err := a.checkKey(dst); err != nil {
+		return wrapError("copy", dst, err)
+	}
+
+	e := a.sse(nil)
+
+	input := &s3.CopyObjectInput{
+		Bucket:                         aws.String(a.bucket),
+		Key:                            aws.String(a.key(dst)),
+		CopySource:                     aws.String(a.copySource(src)),
+		CopySourceSSECustomerAlgorithm: e.customerAlgorithm,
+		CopySourceSSECustomerKey:       e.customerKey,
+		SSECustomerAlgorithm:           e.customerAlgorithm,
+		SSECustomerKey:                 e.customerKey,
+		SSEKMSKeyId:                    e.kmsKeyID,
+		ServerSideEncryption:           e.algorithm,
+	}
+
+	_, err := a.s3.CopyObjectWithContext(a.ctx, input, a.encryptionContext(nil)...)
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == "KeyTooLongError" {
+		return wrapError("copy", dst, err)
+	}
 
-	return err
+	if err != nil {
+		return wrapError("copy", src, err)
+	}
+
+	return wrapError("copy", dst, a.written(dst))
 }
 
-// CreateDir will create a directory.
-func (a *Adapter) CreateDir(path string, args ...interface{}) error {
-	return a.Write(strings.TrimRight(path, "/")+"/", "")
+// CreateDir will create a directory by writing a directory marker,
+// it does nothing with MarkersNever.
+func (a *Adapter) CreateDir(path string, opts ...adapter.Option) error {
+	if a.markers == MarkersNever {
+		return nil
+	}
+
+	return a.Write(strings.TrimRight(path, "/")+"/", "", opts...)
 }
 
 // Delete will delete a file on AWS S3.
 func (a *Adapter) Delete(path string) error {
-	_, err := a.s3.DeleteObject(&s3.DeleteObjectInput{
+	_, err := a.s3.DeleteObjectWithContext(a.ctx, &s3.DeleteObjectInput{
 		Bucket: aws.String(a.bucket),
-		Key:    aws.String(path),
+		Key:    aws.String(a.key(path)),
 	})
 
-	return err
+	if err == nil {
+		err = a.deleted(path)
+	}
+
+	return wrapError("delete", path, err)
 }
 
-// DeleteDir will delete a directory.
+// DeleteDir will delete a directory and every key under its prefix on AWS S3,
+// including the markers of the directory and its sub directories. The keys are
+// deleted page by page with batched DeleteObjects requests. The root is
+// rejected with adapter.ErrInvalidPath.
 func (a *Adapter) DeleteDir(path string) error {
-	return a.Delete(strings.TrimRight(path, "/") + "/")
-}
+	if strings.Trim(path, "/") == "" {
+		return wrapError("deletedir", path, adapter.ErrInvalidPath)
+	}
 
-// Has will check whether a file exists.
-func (a *Adapter) Has(path string) (bool, error) {
-	_, err := a.s3.HeadObject(&s3.HeadObjectInput{
+	found := false
+
+	var deleteErr error
+	err := a.s3.ListObjectsV2PagesWithContext(a.ctx, &s3.ListObjectsV2Input{
 		Bucket: aws.String(a.bucket),
-		Key:    aws.String(path),
+		Prefix: aws.String(a.key(strings.Trim(path, "/") + "/")),
+	}, func(page *s3.ListObjectsV2Output, last bool) bool {
+		objects := make([]*s3.ObjectIdentifier, 0, len(page.Contents))
+		for _, obj := range page.Contents {
+			objects = append(objects, &s3.ObjectIdentifier{Key: obj.Key})
+		}
+
+		found = found || len(objects) > 0
+		deleteErr = a.deleteObjects(objects)
+
+		return deleteErr == nil
+	})
+
+	if err == nil {
+		err = deleteErr
+	}
+
+	if err == nil && !found {
+		err = adapter.ErrNotExist
+	}
+
+	if err == nil {
+		err = a.deleted(path)
+	}
+
+	return wrapError("deletedir", path, err)
+}
+
+// DeleteEmptyDir will delete a directory marker on AWS S3 only if
+// there are no other keys under the directory prefix. The root is
+// rejected with adapter.ErrInvalidPath.
+func (a *Adapter) DeleteEmptyDir(path string) error {
+	if strings.Trim(path, "/") == "" {
+		return wrapError("deleteemptydir", path, adapter.ErrInvalidPath)
+	}
+
+	prefix := a.key(strings.Trim(path, "/") + "/")
+
+	res, err := a.s3.ListObjectsV2WithContext(a.ctx, &s3.ListObjectsV2Input{
+		Bucket:  aws.String(a.bucket),
+		Prefix:  aws.String(prefix),
+		MaxKeys: aws.Int64(2),
 	})
 
 	if err != nil {
-		return false, err
+		return wrapError("deleteemptydir", path, err)
+	}
+
+	if len(res.Contents) == 0 {
+		return wrapError("deleteemptydir", path, adapter.ErrNotExist)
+	}
+
+	for _, obj := range res.Contents {
+		if aws.StringValue(obj.Key) != prefix {
+			return wrapError("deleteemptydir", path, adapter.ErrDirNotEmpty)
+		}
+	}
+
+	_, err = a.s3.DeleteObjectWithContext(a.ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(a.bucket),
+		Key:    aws.String(prefix),
+	})
+
+	if err == nil {
+		err = a.deleted(path)
+	}
+
+	return wrapError("deleteemptydir", path, err)
+}
+
+// deleteObjects deletes the objects in batches of up to 1000 keys,
+// the most AWS S3 accepts in a single DeleteObjects request.
+func (a *Adapter) deleteObjects(objects []*s3.ObjectIdentifier) error {
+	failed := &DeleteError{}
+
+	for len(objects) > 0 {
+		n := len(objects)
+		if n > maxDeleteObjects {
+			n = maxDeleteObjects
+		}
+
+		res, err := a.s3.DeleteObjectsWithContext(a.ctx, &s3.DeleteObjectsInput{
+			Bucket: aws.String(a.bucket),
+			Delete: &s3.Delete{
+				Objects: objects[:n],
+				Quiet:   aws.Bool(true),
+			},
+		})
+
+		if err != nil {
+			return err
+		}
+
+		for _, e := range res.Errors {
+			failed.Errors = append(failed.Errors, KeyError{
+				Key:     a.relative(aws.StringValue(e.Key)),
+				Code:    aws.StringValue(e.Code),
+				Message: aws.StringValue(e.Message),
+			})
+		}
+
+		objects = objects[n:]
+	}
+
+	if len(failed.Errors) > 0 {
+		return failed
+	}
+
+	return nil
+}
+
+// Has will check whether a file exists.
+func (a *Adapter) Has(path string) (bool, error) {
+	_, err := a.s3.HeadObjectWithContext(a.ctx, a.headObjectInput(path))
+
+	if err == nil {
+		return true, nil
+	}
+
+	if err = wrapError("has", path, err); errors.Is(err, adapter.ErrNotExist) {
+		return false, nil
 	}
 
-	return true, nil
+	return false, err
 }
 
-// HasDir will check whether a directory exists.
+// HasDir will check whether a directory exists, which it does when there's any
+// key under its prefix, e.g. a directory marker or a file in it or a sub
+// directory. It's checked with a single ListObjectsV2 request of one key.
 func (a *Adapter) HasDir(path string) (bool, error) {
-	return a.Has(strings.TrimRight(path, "/") + "/")
+	if strings.Trim(path, "/") == "" {
+		return true, nil
+	}
+
+	empty, err := a.isEmpty(path)
+	if err != nil {
+		return false, wrapError("hasdir", path, err)
+	}
+
+	return !empty, nil
 }
 
-// MimeType will return the file mime type.
-func (a *Adapter) MimeType(path string) (string, error) {
-	res, err := a.s3.HeadObject(&s3.HeadObjectInput{
+// ListContents will list the files and directories in a directory on AWS S3.
+// Shallow listings use a delimiter so sub directories are returned as
+// common prefixes, recursive listings include every implied directory.
+func (a *Adapter) ListContents(dir string, recursive bool) ([]adapter.Entry, error) {
+	prefix := a.dirKey(dir)
+
+	input := &s3.ListObjectsV2Input{
 		Bucket: aws.String(a.bucket),
-		Key:    aws.String(path),
+		Prefix: aws.String(prefix),
+	}
+
+	if !recursive {
+		input.Delimiter = aws.String("/")
+	}
+
+	seen := map[string]bool{}
+	entries := []adapter.Entry{}
+
+	addDir := func(path string) {
+		path = strings.TrimRight(path, "/")
+		if path == "" || path+"/" == prefix || seen[path] {
+			return
+		}
+
+		seen[path] = true
+		entries = append(entries, adapter.Entry{Path: a.relative(path), Type: adapter.TypeDir})
+	}
+
+	err := a.s3.ListObjectsV2PagesWithContext(a.ctx, input, func(page *s3.ListObjectsV2Output, last bool) bool {
+		for _, p := range page.CommonPrefixes {
+			addDir(aws.StringValue(p.Prefix))
+		}
+
+		for _, obj := range page.Contents {
+			key := aws.StringValue(obj.Key)
+
+			if recursive {
+				parts := strings.Split(strings.TrimPrefix(key, prefix), "/")
+				for i := 1; i < len(parts); i++ {
+					addDir(prefix + strings.Join(parts[:i], "/"))
+				}
+			}
+
+			if strings.HasSuffix(key, "/") {
+				addDir(key)
+				continue
+			}
+
+			entries = append(entries, adapter.Entry{
+				Path:         a.relative(key),
+				Type:         adapter.TypeFile,
+				Size:         a.plainSize(aws.Int64Value(obj.Size), nil),
+				LastModified: aws.TimeValue(obj.LastModified),
+			})
+		}
+
+		return true
 	})
 
+	if err != nil {
+		return nil, wrapError("list", dir, err)
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Path < entries[j].Path
+	})
+
+	return entries, nil
+}
+
+// MimeType will return the file mime type.
+func (a *Adapter) MimeType(path string) (string, error) {
+	info, err := a.Stat(path)
 	if err != nil {
 		return "", err
 	}
 
-	return string(*res.ContentType), nil
+	return info.MimeType, nil
 }
 
 // Read will read a file on AWS S3.
 func (a *Adapter) Read(path string) (string, error) {
-	res, err := a.s3.GetObject(&s3.GetObjectInput{
-		Bucket: aws.String(a.bucket),
-		Key:    aws.String(path),
-	})
+	res, err := a.getObject(a.getObjectInput(path))
 
 	if err != nil {
-		return "", err
+		return "", wrapError("read", path, err)
 	}
 
+	defer res.Body.Close()
+
 	buf, err := ioutil.ReadAll(res.Body)
 
 	if err != nil {
-		return "", err
+		return "", wrapError("read", path, err)
 	}
 
 	return string(buf), nil
 }
 
+// ReadStream will return the body of a file on AWS S3.
+func (a *Adapter) ReadStream(path string) (io.ReadCloser, error) {
+	res, err := a.getObject(a.getObjectInput(path))
+
+	if err != nil {
+		return nil, wrapError("read", path, err)
+	}
+
+	return res.Body, nil
+}
+
 // ReadAndDelete will read a file and delete it if any.
 func (a *Adapter) ReadAndDelete(path string) (string, error) {
 	content, err := a.Read(path)
@@ -120,7 +449,8 @@ func (a *Adapter) ReadAndDelete(path string) (string, error) {
 	return content, a.Delete(path)
 }
 
-// Rename will rename a file to a new path on AWS S3.
+// Rename will rename a file to a new path on AWS S3 by copying and deleting it,
+// since AWS S3 can't rename keys.
 func (a *Adapter) Rename(src string, dst string) error {
 	if err := a.Copy(src, dst); err != nil {
 		return err
@@ -129,23 +459,252 @@ func (a *Adapter) Rename(src string, dst string) error {
 	return a.Delete(src)
 }
 
-// Write will write a a new file AWS S3.
-func (a *Adapter) Write(path, content string, args ...interface{}) error {
-	res, err := a.s3.PutObject(&s3.PutObjectInput{
-		Bucket:        aws.String(a.bucket),
-		Key:           aws.String(path),
-		Body:          bytes.NewReader([]byte(content)),
-		ContentLength: aws.Int64(int64(len(content))),
-		ContentType:   aws.String(mime.TypeByExtension(filepath.Ext(path))),
+// SetVisibility will change the visibility of a file on AWS S3
+// with the public-read or private canned ACL.
+func (a *Adapter) SetVisibility(path, visibility string) error {
+	input := &s3.PutObjectAclInput{
+		Bucket: aws.String(a.bucket),
+		Key:    aws.String(a.key(path)),
+	}
+
+	switch visibility {
+	case adapter.VisibilityPublic:
+		input.ACL = aws.String(s3.ObjectCannedACLPublicRead)
+	case adapter.VisibilityPrivate:
+		input.ACL = aws.String(s3.ObjectCannedACLPrivate)
+	default:
+		return wrapError("setvisibility", path, adapter.ErrInvalidVisibility)
+	}
+
+	_, err := a.s3.PutObjectAclWithContext(a.ctx, input)
+
+	return wrapError("setvisibility", path, err)
+}
+
+// Stat will return the metadata of a file on AWS S3 using a single HeadObject call.
+// The visibility is left empty since it requires a separate ACL request.
+func (a *Adapter) Stat(path string) (adapter.FileInfo, error) {
+	res, err := a.s3.HeadObjectWithContext(a.ctx, a.headObjectInput(path))
+
+	if err != nil {
+		return adapter.FileInfo{}, wrapError("stat", path, err)
+	}
+
+	return adapter.FileInfo{
+		Path:         path,
+		Size:         a.plainSize(aws.Int64Value(res.ContentLength), res.Metadata),
+		LastModified: aws.TimeValue(res.LastModified),
+		MimeType:     aws.StringValue(res.ContentType),
+		ETag:         strings.Trim(aws.StringValue(res.ETag), `"`),
+	}, nil
+}
+
+// Update will overwrite a existing file on AWS S3. The ETag from HeadObject is
+// sent as If-Match, so the write fails if the file is replaced in between.
+// It fails with adapter.ErrNotExist when the file is missing.
+func (a *Adapter) Update(path, content string, opts ...adapter.Option) error {
+	res, err := a.s3.HeadObjectWithContext(a.ctx, a.headObjectInput(path))
+
+	if err != nil {
+		return wrapError("update", path, err)
+	}
+
+	err = a.put(path, strings.NewReader(content), adapter.NewConfig(opts...), setHeader("If-Match", aws.StringValue(res.ETag)))
+	return wrapError("update", path, err)
+}
+
+// URL will return the URL of a file on AWS S3 for the client's endpoint,
+// the file is only accessible through it with public visibility.
+func (a *Adapter) URL(path string) (string, error) {
+	req, _ := a.s3.GetObjectRequest(&s3.GetObjectInput{
+		Bucket: aws.String(a.bucket),
+		Key:    aws.String(a.key(path)),
+	})
+
+	if err := req.Build(); err != nil {
+		return "", wrapError("url", path, err)
+	}
+
+	return req.HTTPRequest.URL.String(), nil
+}
+
+// Visibility will return the visibility of a file on AWS S3,
+// it's public when all users are granted read access.
+func (a *Adapter) Visibility(path string) (string, error) {
+	res, err := a.s3.GetObjectAclWithContext(a.ctx, &s3.GetObjectAclInput{
+		Bucket: aws.String(a.bucket),
+		Key:    aws.String(a.key(path)),
 	})
 
+	if err != nil {
+		return "", wrapError("visibility", path, err)
+	}
+
+	for _, grant := range res.Grants {
+		if grant.Grantee == nil || aws.StringValue(grant.Grantee.URI) != allUsersURI {
+			continue
+		}
+
+		switch aws.StringValue(grant.Permission) {
+		case s3.PermissionRead, s3.PermissionFullControl:
+			return adapter.VisibilityPublic, nil
+		}
+	}
+
+	return adapter.VisibilityPrivate, nil
+}
+
+// Write will write a a new file AWS S3.
+func (a *Adapter) Write(path, content string, opts ...adapter.Option) error {
+	return a.WriteStream(path, strings.NewReader(content), opts...)
+}
+
+// WriteNew will write a new file on AWS S3 with a conditional If-None-Match request,
+// it fails with adapter.ErrExist when the file exists.
+func (a *Adapter) WriteNew(path, content string, opts ...adapter.Option) error {
+	err := a.put(path, strings.NewReader(content), adapter.NewConfig(opts...), setHeader("If-None-Match", "*"))
+
+	if aerr, ok := err.(awserr.Error); ok {
+		switch aerr.Code() {
+		case "PreconditionFailed", "ConditionalRequestConflict":
+			err = adapter.ErrExist
+		}
+	}
+
+	if err == nil {
+		err = a.written(path)
+	}
+
+	return wrapError("writenew", path, err)
+}
+
+// WriteStream will write a new file to AWS S3 from a reader. Files larger
+// than the part size are uploaded in parallel parts with a multipart upload,
+// which is aborted when the write fails.
+func (a *Adapter) WriteStream(path string, r io.Reader, opts ...adapter.Option) error {
+	err := a.upload(path, r, adapter.NewConfig(opts...))
+	if err == nil {
+		err = a.written(path)
+	}
+
+	return wrapError("write", path, err)
+}
+
+// put uploads the body with a single PutObject request.
+func (a *Adapter) put(path string, body io.ReadSeeker, cfg *adapter.Config, reqOpts ...request.Option) error {
+	if err := a.checkKey(path); err != nil {
+		return err
+	}
+
+	reqOpts = append(reqOpts, a.encryptionContext(cfg.Encryption)...)
+
+	res, err := a.putObject(a.putObjectInput(path, body, cfg), reqOpts...)
 	if err != nil {
 		return err
 	}
 
-	if len(*res.ETag) == 0 {
+	if len(aws.StringValue(res.ETag)) == 0 {
 		return errors.New("No ETag created for path")
 	}
 
 	return nil
 }
+
+// setHeader returns a request option that sets a HTTP header.
+func setHeader(key, value string) request.Option {
+	return func(r *request.Request) {
+		r.HTTPRequest.Header.Set(key, value)
+	}
+}
+
+// getObjectInput returns the GetObject input for the path,
+// with the customer key of the default encryption.
+func (a *Adapter) getObjectInput(path string) *s3.GetObjectInput {
+	algorithm, key := a.customerKey()
+
+	return &s3.GetObjectInput{
+		Bucket:               aws.String(a.bucket),
+		Key:                  aws.String(a.key(path)),
+		SSECustomerAlgorithm: algorithm,
+		SSECustomerKey:       key,
+	}
+}
+
+// headObjectInput returns the HeadObject input for the path,
+// with the customer key of the default encryption.
+func (a *Adapter) headObjectInput(path string) *s3.HeadObjectInput {
+	algorithm, key := a.customerKey()
+
+	return &s3.HeadObjectInput{
+		Bucket:               aws.String(a.bucket),
+		Key:                  aws.String(a.key(path)),
+		SSECustomerAlgorithm: algorithm,
+		SSECustomerKey:       key,
+	}
+}
+
+// putObjectInput maps the config onto the PutObject headers.
+// The content type is guessed from the extension when not set
+// and the encryption defaults to the one of the adapter.
+func (a *Adapter) putObjectInput(path string, body io.ReadSeeker, cfg *adapter.Config) *s3.PutObjectInput {
+	e := a.sse(cfg.Encryption)
+
+	input := &s3.PutObjectInput{
+		Bucket:               aws.String(a.bucket),
+		Key:                  aws.String(a.key(path)),
+		Body:                 body,
+		ContentType:          aws.String(cfg.ContentType),
+		SSECustomerAlgorithm: e.customerAlgorithm,
+		SSECustomerKey:       e.customerKey,
+		SSEKMSKeyId:          e.kmsKeyID,
+		ServerSideEncryption: e.algorithm,
+	}
+
+	if cfg.ContentType == "" {
+		input.ContentType = aws.String(mime.TypeByExtension(filepath.Ext(path)))
+	}
+
+	if cfg.CacheControl != "" {
+		input.CacheControl = aws.String(cfg.CacheControl)
+	}
+
+	if cfg.ContentDisposition != "" {
+		input.ContentDisposition = aws.String(cfg.ContentDisposition)
+	}
+
+	if len(cfg.Metadata) > 0 {
+		input.Metadata = aws.StringMap(cfg.Metadata)
+	}
+
+	switch cfg.Visibility {
+	case adapter.VisibilityPublic:
+		input.ACL = aws.String(s3.ObjectCannedACLPublicRead)
+	case adapter.VisibilityPrivate:
+		input.ACL = aws.String(s3.ObjectCannedACLPrivate)
+	}
+
+	return input
+}
+
+// wrapError maps a AWS S3 error onto the adapter errors
+// and wraps it in a *adapter.PathError.
+func wrapError(op, path string, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	// HeadObject responses have no body, so the SDK uses the
+	// HTTP status text as code, e.g. NotFound and Forbidden.
+	if aerr, ok := err.(awserr.Error); ok {
+		switch aerr.Code() {
+		case s3.ErrCodeNoSuchKey, "NotFound":
+			err = adapter.ErrNotExist
+		case "AccessDenied", "Forbidden":
+			err = adapter.ErrPermission
+		case "KeyTooLongError":
+			err = adapter.ErrInvalidPath
+		}
+	}
+
+	return &adapter.PathError{Op: op, Path: path, Adapter: "flys3", Err: err}
+}
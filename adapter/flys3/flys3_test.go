@@ -2,25 +2,58 @@ package flys3
 
 import (
 	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
 	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
 	"strings"
 	"sync"
 	"testing"
+	"testing/fstest"
+	"time"
 
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/aws/aws-sdk-go/service/kms/kmsiface"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
 	"github.com/frozzare/go-assert"
+	"github.com/frozzare/go-fly"
+	"github.com/frozzare/go-fly/adapter"
+	"github.com/frozzare/go-fly/flytest"
 )
 
 var (
-	ErrNoSuchBucket  = errors.New("NoSuchBucket: The specified bucket does not exist")
-	ErrBucketExists  = errors.New("Bucket already exists")
-	ErrBucketHasKeys = errors.New("Bucket has keys so cannot be deleted")
-	ErrMisingKey     = errors.New("Missing key")
-	client           = &MockS3{data: map[string]MockBucket{
+	ErrNoSuchBucket       = errors.New("NoSuchBucket: The specified bucket does not exist")
+	ErrBucketExists       = errors.New("Bucket already exists")
+	ErrBucketHasKeys      = errors.New("Bucket has keys so cannot be deleted")
+	ErrNoSuchKey          = awserr.NewRequestFailure(awserr.New(s3.ErrCodeNoSuchKey, "The specified key does not exist.", nil), 404, "")
+	ErrPreconditionFailed = awserr.NewRequestFailure(awserr.New("PreconditionFailed", "At least one of the pre-conditions you specified did not hold", nil), 412, "")
+	ErrNotFound           = awserr.NewRequestFailure(awserr.New("NotFound", "Not Found", nil), 404, "")
+	ErrInvalidRange       = awserr.NewRequestFailure(awserr.New("InvalidRange", "The requested range is not satisfiable", nil), 416, "")
+	ErrInvalidRequest     = awserr.NewRequestFailure(awserr.New("InvalidRequest", "The object was stored using a form of Server Side Encryption. The correct parameters must be provided to retrieve the object.", nil), 400, "")
+	client                = &MockS3{data: map[string]MockBucket{
 		"/tmp": MockBucket{},
-	}}
+		"iofs": MockBucket{},
+	}, uploads: map[string]*MockUpload{}}
+	mockTime      = time.Date(2017, 9, 5, 21, 58, 59, 0, time.UTC)
+	requestClient = s3.New(session.Must(session.NewSession(&aws.Config{
+		Credentials: credentials.NewStaticCredentials("id", "secret", ""),
+		Region:      aws.String("eu-west-1"),
+	})))
 )
 
 func TestDirectory(t *testing.T) {
@@ -38,78 +71,1272 @@ func TestDirectory(t *testing.T) {
 
 	has, err = fs.HasDir("test/folder")
 	assert.False(t, has)
+	assert.Nil(t, err)
+}
+
+func TestFile(t *testing.T) {
+	fs := NewAdapter(client, "/tmp")
+
+	err := fs.Write("test/hello.txt", "Hello, world!")
+	assert.Nil(t, err)
+
+	err = fs.Copy("test/hello.txt", "test/hello-copy.txt")
+	assert.Nil(t, err)
+
+	has, err := fs.Has("test/hello.txt")
+	assert.True(t, has)
+	assert.Nil(t, err)
+
+	content, err := fs.ReadAndDelete("test/hello-copy.txt")
+	assert.Nil(t, err)
+	assert.Equal(t, "Hello, world!", strings.TrimSpace(content))
+
+	has, err = fs.Has("test/hello-copy.txt")
+	assert.False(t, has)
+	assert.Nil(t, err)
+}
+
+func TestFileMimeType(t *testing.T) {
+	fs := NewAdapter(client, "/tmp")
+
+	err := fs.Write("test/hello.txt", "Hello, world!")
+	assert.Nil(t, err)
+
+	typ, err := fs.MimeType("test/hello.txt")
+	assert.Equal(t, "text/plain", typ)
+}
+
+func TestFileStream(t *testing.T) {
+	fs := NewAdapter(client, "/tmp")
+
+	// Hide the Seek method so the reader has to be spooled.
+	r := struct{ io.Reader }{strings.NewReader("Hello, stream!")}
+
+	err := fs.WriteStream("test/stream.txt", r)
+	assert.Nil(t, err)
+
+	body, err := fs.ReadStream("test/stream.txt")
+	assert.Nil(t, err)
+
+	buf, err := ioutil.ReadAll(body)
+	assert.Nil(t, err)
+	assert.Nil(t, body.Close())
+	assert.Equal(t, "Hello, stream!", string(buf))
+}
+
+func TestListContents(t *testing.T) {
+	fs := NewAdapter(client, "/tmp")
+
+	assert.Nil(t, fs.Write("list/a.txt", "a"))
+	assert.Nil(t, fs.Write("list/sub/b.txt", "bb"))
+	assert.Nil(t, fs.CreateDir("list/empty"))
+
+	entries, err := fs.ListContents("list", false)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(entries))
+	assert.Equal(t, "list/a.txt", entries[0].Path)
+	assert.Equal(t, int64(1), entries[0].Size)
+	assert.Equal(t, "list/empty", entries[1].Path)
+	assert.True(t, entries[1].IsDir())
+	assert.Equal(t, "list/sub", entries[2].Path)
+	assert.True(t, entries[2].IsDir())
+
+	entries, err = fs.ListContents("list", true)
+	assert.Nil(t, err)
+	assert.Equal(t, 4, len(entries))
+	assert.Equal(t, "list/sub/b.txt", entries[3].Path)
+	assert.Equal(t, int64(2), entries[3].Size)
+}
+
+func TestStat(t *testing.T) {
+	fs := NewAdapter(client, "/tmp")
+
+	err := fs.Write("test/hello.txt", "Hello, world!")
+	assert.Nil(t, err)
+
+	info, err := fs.Stat("test/hello.txt")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(13), info.Size)
+	assert.Equal(t, "text/plain", info.MimeType)
+	assert.Equal(t, "6cd3556deb0da54bca060b4c39479839", info.ETag)
+	assert.False(t, info.LastModified.IsZero())
+}
+
+func TestErrors(t *testing.T) {
+	fs := NewAdapter(client, "/tmp")
+
+	_, err := fs.Read("test/missing.txt")
+	assert.True(t, errors.Is(err, adapter.ErrNotExist))
+
+	_, err = fs.Stat("test/missing.txt")
+	assert.True(t, errors.Is(err, adapter.ErrNotExist))
+
+	err = fs.Copy("test/missing.txt", "test/missing-copy.txt")
+	assert.True(t, errors.Is(err, adapter.ErrNotExist))
+
+	pathErr, ok := err.(*adapter.PathError)
+	assert.True(t, ok)
+	assert.Equal(t, "copy", pathErr.Op)
+	assert.Equal(t, "flys3", pathErr.Adapter)
+
+	_, err = NewAdapter(client, "missing").Has("test/hello.txt")
+	assert.NotNil(t, err)
+}
+
+func TestWriteOptions(t *testing.T) {
+	fs := NewAdapter(client, "/tmp")
+
+	err := fs.Write("test/report.bin", "report",
+		adapter.WithVisibility(adapter.VisibilityPublic),
+		adapter.WithContentType("application/pdf"),
+		adapter.WithCacheControl("max-age=60"),
+		adapter.WithContentDisposition("attachment"),
+		adapter.WithMetadata(map[string]string{"owner": "fly"}))
+	assert.Nil(t, err)
+
+	input := client.lastPut
+	assert.Equal(t, "public-read", aws.StringValue(input.ACL))
+	assert.Equal(t, "application/pdf", aws.StringValue(input.ContentType))
+	assert.Equal(t, "max-age=60", aws.StringValue(input.CacheControl))
+	assert.Equal(t, "attachment", aws.StringValue(input.ContentDisposition))
+	assert.Equal(t, "fly", aws.StringValue(input.Metadata["owner"]))
+
+	err = fs.Write("test/hello.txt", "Hello, world!")
+	assert.Nil(t, err)
+	assert.Nil(t, client.lastPut.ACL)
+	assert.Equal(t, "text/plain; charset=utf-8", aws.StringValue(client.lastPut.ContentType))
+}
+
+func TestContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	fs := NewAdapter(client, "/tmp").WithContext(ctx)
+
+	err := fs.Write("test/hello.txt", "Hello, world!")
+	assert.Nil(t, err)
+
+	cancel()
+
+	_, err = fs.Read("test/hello.txt")
+	assert.True(t, errors.Is(err, context.Canceled))
+
+	_, err = NewAdapter(client, "/tmp").Read("test/hello.txt")
+	assert.Nil(t, err)
+}
+
+func TestDeleteDir(t *testing.T) {
+	fs := NewAdapter(client, "/tmp")
+
+	assert.Nil(t, fs.CreateDir("tree"))
+	for i := 0; i < 1500; i++ {
+		assert.Nil(t, fs.Write(fmt.Sprintf("tree/sub/%d.txt", i), "x"))
+	}
+
+	err := fs.DeleteEmptyDir("tree")
+	assert.True(t, errors.Is(err, adapter.ErrDirNotEmpty))
+
+	batches := client.deleteBatches
+	err = fs.DeleteDir("tree")
+	assert.Nil(t, err)
+	assert.Equal(t, 2, client.deleteBatches-batches)
+
+	entries, err := fs.ListContents("tree", true)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(entries))
+
+	err = fs.DeleteDir("tree")
+	assert.True(t, errors.Is(err, adapter.ErrNotExist))
+
+	assert.Nil(t, fs.CreateDir("empty"))
+	assert.Nil(t, fs.DeleteEmptyDir("empty"))
+
+	has, err := fs.HasDir("empty")
+	assert.False(t, has)
+	assert.Nil(t, err)
+
+	assert.Nil(t, fs.Write("locked/a.txt", "a"))
+	err = fs.DeleteDir("locked")
+
+	var deleteErr *DeleteError
+	assert.True(t, errors.As(err, &deleteErr))
+	assert.Equal(t, "locked/a.txt", deleteErr.Errors[0].Key)
+	assert.Equal(t, "AccessDenied", deleteErr.Errors[0].Code)
+}
+
+func TestConditionalWrites(t *testing.T) {
+	fs := NewAdapter(client, "/tmp")
+	assert.Nil(t, fs.Delete("cond/new.txt"))
+
+	err := fs.Update("cond/new.txt", "updated")
+	assert.True(t, errors.Is(err, adapter.ErrNotExist))
+
+	err = fs.WriteNew("cond/new.txt", "new")
+	assert.Nil(t, err)
+
+	err = fs.WriteNew("cond/new.txt", "new")
+	assert.True(t, errors.Is(err, adapter.ErrExist))
+
+	err = fs.Update("cond/new.txt", "updated")
+	assert.Nil(t, err)
+
+	content, err := fs.Read("cond/new.txt")
+	assert.Nil(t, err)
+	assert.Equal(t, "updated", content)
+}
+
+func TestFS(t *testing.T) {
+	fs := fly.NewFly(NewAdapter(client, "iofs"))
+
+	assert.Nil(t, fs.Write("index.html", "<h1>Fly</h1>"))
+	assert.Nil(t, fs.CreateDir("static"))
+	assert.Nil(t, fs.Write("static/app.js", "console.log('fly')"))
+
+	err := fstest.TestFS(fs.FS(), "index.html", "static/app.js")
+	assert.Nil(t, err)
+}
+
+func TestVisibility(t *testing.T) {
+	fs := NewAdapter(client, "/tmp")
+
+	err := fs.Write("test/hello.txt", "Hello, world!", adapter.WithVisibility(adapter.VisibilityPublic))
+	assert.Nil(t, err)
+
+	visibility, err := fs.Visibility("test/hello.txt")
+	assert.Nil(t, err)
+	assert.Equal(t, adapter.VisibilityPublic, visibility)
+
+	err = fs.SetVisibility("test/hello.txt", adapter.VisibilityPrivate)
+	assert.Nil(t, err)
+
+	visibility, err = fs.Visibility("test/hello.txt")
+	assert.Nil(t, err)
+	assert.Equal(t, adapter.VisibilityPrivate, visibility)
+}
+
+func TestURL(t *testing.T) {
+	fs := NewAdapter(client, "fly")
+
+	u, err := fs.URL("test/hello world.txt")
+	assert.Nil(t, err)
+	assert.Equal(t, "https://fly.s3-eu-west-1.amazonaws.com/test/hello%20world.txt", u)
+}
+
+func TestMultipartUpload(t *testing.T) {
+	fs := NewAdapter(client, client.createBucket("multipart"), WithPartSize(1), WithConcurrency(2))
+	content := strings.Repeat("fly", int(s3manager.MinUploadPartSize))
+
+	err := fs.Write("large.txt", content, adapter.WithVisibility(adapter.VisibilityPublic))
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(client.lastComplete.MultipartUpload.Parts))
+	assert.Equal(t, "large.txt", aws.StringValue(client.lastComplete.Key))
+
+	read, err := fs.Read("large.txt")
+	assert.Nil(t, err)
+	assert.True(t, read == content)
+
+	visibility, err := fs.Visibility("large.txt")
+	assert.Nil(t, err)
+	assert.Equal(t, adapter.VisibilityPublic, visibility)
+
+	// Hide the Seek method so the reader has to be buffered.
+	err = fs.WriteStream("stream.txt", struct{ io.Reader }{strings.NewReader(content)})
+	assert.Nil(t, err)
+	assert.Equal(t, "stream.txt", aws.StringValue(client.lastComplete.Key))
+
+	read, err = fs.Read("stream.txt")
+	assert.Nil(t, err)
+	assert.True(t, read == content)
+
+	err = fs.WriteStream("small.txt", struct{ io.Reader }{strings.NewReader("small")})
+	assert.Nil(t, err)
+	assert.Equal(t, "small.txt", aws.StringValue(client.lastPut.Key))
+
+	err = fs.Write("broken.txt", content)
+	assert.NotNil(t, err)
+	assert.Equal(t, 0, len(client.uploads))
+
+	has, err := fs.Has("broken.txt")
+	assert.Nil(t, err)
+	assert.False(t, has)
+}
+
+func TestAbortStaleUploads(t *testing.T) {
+	fs := NewAdapter(client, client.createBucket("stale"))
+
+	client.Lock()
+	client.uploads["stale-1"] = &MockUpload{bucket: "stale", key: "a.txt", initiated: time.Now().Add(-2 * time.Hour)}
+	client.uploads["stale-2"] = &MockUpload{bucket: "stale", key: "b.txt", initiated: time.Now()}
+	client.uploads["other"] = &MockUpload{bucket: "/tmp", key: "c.txt", initiated: time.Now().Add(-2 * time.Hour)}
+	client.Unlock()
+
+	n, err := fs.AbortStaleUploads(time.Hour)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, n)
+	assert.Nil(t, client.uploads["stale-1"])
+	assert.NotNil(t, client.uploads["stale-2"])
+
+	n, err = fs.AbortStaleUploads(-time.Hour)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, n)
+	assert.NotNil(t, client.uploads["other"])
+
+	client.Lock()
+	delete(client.uploads, "other")
+	client.Unlock()
+}
+
+func TestDownload(t *testing.T) {
+	fs := NewAdapter(client, client.createBucket("download"), WithConcurrency(3))
+	content := strings.Repeat("fly", int(s3manager.DefaultDownloadPartSize))
+
+	assert.Nil(t, fs.Write("large.txt", content))
+	assert.Nil(t, fs.Write("empty.txt", ""))
+
+	ranges := len(client.ranges)
+	buf := aws.NewWriteAtBuffer(nil)
+
+	n, err := fs.Download("large.txt", buf)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(len(content)), n)
+	assert.Equal(t, 3, len(client.ranges)-ranges)
+	assert.True(t, string(buf.Bytes()) == content)
+
+	n, err = fs.Download("empty.txt", aws.NewWriteAtBuffer(nil))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), n)
+
+	_, err = fs.Download("missing.txt", aws.NewWriteAtBuffer(nil))
+	assert.True(t, errors.Is(err, adapter.ErrNotExist))
+}
+
+func TestReadRange(t *testing.T) {
+	fs := NewAdapter(client, "/tmp")
+
+	assert.Nil(t, fs.Write("test/hello.txt", "Hello, world!"))
+
+	r, err := fs.ReadRange("test/hello.txt", 7, 5)
+	assert.Nil(t, err)
+	assert.Equal(t, "bytes=7-11", client.ranges[len(client.ranges)-1])
+
+	buf, err := ioutil.ReadAll(r)
+	assert.Nil(t, err)
+	assert.Nil(t, r.Close())
+	assert.Equal(t, "world", string(buf))
+
+	_, err = fs.ReadRange("test/hello.txt", 7, -1)
+	assert.Nil(t, err)
+	assert.Equal(t, "bytes=7-", client.ranges[len(client.ranges)-1])
+}
+
+func TestTemporaryURL(t *testing.T) {
+	fs := NewAdapter(client, "fly")
+
+	s, err := fs.TemporaryURL("test/hello world.txt", time.Hour,
+		adapter.WithContentType("text/plain"),
+		adapter.WithContentDisposition(`attachment; filename="hello.txt"`))
+	assert.Nil(t, err)
+
+	u, err := url.Parse(s)
+	assert.Nil(t, err)
+	assert.Equal(t, "fly.s3-eu-west-1.amazonaws.com", u.Host)
+	assert.Equal(t, "/test/hello world.txt", u.Path)
+	assert.Equal(t, "3600", u.Query().Get("X-Amz-Expires"))
+	assert.Equal(t, "text/plain", u.Query().Get("response-content-type"))
+	assert.Equal(t, `attachment; filename="hello.txt"`, u.Query().Get("response-content-disposition"))
+	assert.Equal(t, 64, len(u.Query().Get("X-Amz-Signature")))
+
+	s, err = fs.TemporaryUploadURL("test/hello.txt", 15*time.Minute, adapter.WithVisibility(adapter.VisibilityPublic))
+	assert.Nil(t, err)
+
+	u, err = url.Parse(s)
+	assert.Nil(t, err)
+	assert.Equal(t, "/test/hello.txt", u.Path)
+	assert.Equal(t, "900", u.Query().Get("X-Amz-Expires"))
+	assert.Equal(t, "content-type;host;x-amz-acl", u.Query().Get("X-Amz-SignedHeaders"))
+}
+
+func TestCapabilities(t *testing.T) {
+	c := fly.NewFly(NewAdapter(client, "fly")).Capabilities()
+	assert.False(t, c.Renamer)
+	assert.True(t, c.ServerSideCopier)
+	assert.True(t, c.RangeReader)
+}
+
+func TestPostPolicy(t *testing.T) {
+	fs := NewAdapter(client, "fly")
+
+	policy, err := fs.PostPolicy("uploads", time.Hour, 1, 1024, adapter.WithContentType("image/png"))
+	assert.Nil(t, err)
+	assert.Equal(t, "https://fly.s3-eu-west-1.amazonaws.com/", policy.URL)
+	assert.Equal(t, "uploads/${filename}", policy.Fields["key"])
+	assert.Equal(t, "image/png", policy.Fields["Content-Type"])
+	assert.Equal(t, "AWS4-HMAC-SHA256", policy.Fields["x-amz-algorithm"])
+	assert.True(t, strings.HasPrefix(policy.Fields["x-amz-credential"], "id/"))
+	assert.True(t, strings.HasSuffix(policy.Fields["x-amz-credential"], "/eu-west-1/s3/aws4_request"))
+	assert.Equal(t, 64, len(policy.Fields["x-amz-signature"]))
+
+	data, err := base64.StdEncoding.DecodeString(policy.Fields["policy"])
+	assert.Nil(t, err)
+
+	var doc struct {
+		Expiration time.Time
+		Conditions []interface{}
+	}
+
+	assert.Nil(t, json.Unmarshal(data, &doc))
+	assert.True(t, doc.Expiration.After(time.Now().Add(59*time.Minute)))
+
+	conditions := fmt.Sprint(doc.Conditions)
+	assert.True(t, strings.Contains(conditions, "map[bucket:fly]"))
+	assert.True(t, strings.Contains(conditions, "[starts-with $key uploads/]"))
+	assert.True(t, strings.Contains(conditions, "map[Content-Type:image/png]"))
+	assert.True(t, strings.Contains(conditions, "[content-length-range 1 1024]"))
+
+	policy, err = fs.PostPolicy("", time.Hour, 0, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, "${filename}", policy.Fields["key"])
+}
+
+func TestPostPolicyEncryption(t *testing.T) {
+	fs := NewAdapter(client, "fly", WithDefaultEncryption(SSEKMS("key", map[string]string{"app": "fly"})))
+
+	policy, err := fs.PostPolicy("uploads", time.Hour, 0, 0)
+	assert.Nil(t, err)
+
+	context := base64.StdEncoding.EncodeToString([]byte(`{"app":"fly"}`))
+	assert.Equal(t, EncryptionKMS, policy.Fields["x-amz-server-side-encryption"])
+	assert.Equal(t, "key", policy.Fields["x-amz-server-side-encryption-aws-kms-key-id"])
+	assert.Equal(t, context, policy.Fields["x-amz-server-side-encryption-context"])
+
+	data, err := base64.StdEncoding.DecodeString(policy.Fields["policy"])
+	assert.Nil(t, err)
+
+	conditions := string(data)
+	assert.True(t, strings.Contains(conditions, `{"x-amz-server-side-encryption":"aws:kms"}`))
+	assert.True(t, strings.Contains(conditions, `{"x-amz-server-side-encryption-aws-kms-key-id":"key"}`))
+	assert.True(t, strings.Contains(conditions, `{"x-amz-server-side-encryption-context":"`+context+`"}`))
+
+	policy, err = fs.PostPolicy("uploads", time.Hour, 0, 0, fly.WithEncryption(SSES3()))
+	assert.Nil(t, err)
+	assert.Equal(t, EncryptionAES256, policy.Fields["x-amz-server-side-encryption"])
+	assert.Equal(t, "", policy.Fields["x-amz-server-side-encryption-aws-kms-key-id"])
+	assert.Equal(t, "", policy.Fields["x-amz-server-side-encryption-context"])
+
+	_, err = fs.PostPolicy("uploads", time.Hour, 0, 0, fly.WithEncryption(SSEC(make([]byte, 32))))
+	assert.True(t, errors.Is(err, adapter.ErrNotSupported))
+
+	_, err = NewAdapter(client, "fly", WithDefaultEncryption(SSEC(make([]byte, 32)))).PostPolicy("uploads", time.Hour, 0, 0)
+	assert.True(t, errors.Is(err, adapter.ErrNotSupported))
+}
+
+func TestEncryption(t *testing.T) {
+	fs := NewAdapter(client, client.createBucket("kms"), WithPartSize(1), WithDefaultEncryption(SSEKMS("key", map[string]string{"app": "fly"})))
+
+	err := fs.Write("kms.txt", "Hello")
+	assert.Nil(t, err)
+
+	context := base64.StdEncoding.EncodeToString([]byte(`{"app":"fly"}`))
+	header := client.header("PutObject")
+	assert.Equal(t, EncryptionKMS, header.Get("X-Amz-Server-Side-Encryption"))
+	assert.Equal(t, "key", header.Get("X-Amz-Server-Side-Encryption-Aws-Kms-Key-Id"))
+	assert.Equal(t, context, header.Get("X-Amz-Server-Side-Encryption-Context"))
+
+	err = fs.Write("aes.txt", "Hello", fly.WithEncryption(SSES3()))
+	assert.Nil(t, err)
+
+	header = client.header("PutObject")
+	assert.Equal(t, EncryptionAES256, header.Get("X-Amz-Server-Side-Encryption"))
+	assert.Equal(t, "", header.Get("X-Amz-Server-Side-Encryption-Aws-Kms-Key-Id"))
+	assert.Equal(t, "", header.Get("X-Amz-Server-Side-Encryption-Context"))
+
+	err = fs.Copy("aes.txt", "copy.txt")
+	assert.Nil(t, err)
+
+	header = client.header("CopyObject")
+	assert.Equal(t, EncryptionKMS, header.Get("X-Amz-Server-Side-Encryption"))
+	assert.Equal(t, "key", header.Get("X-Amz-Server-Side-Encryption-Aws-Kms-Key-Id"))
+	assert.Equal(t, context, header.Get("X-Amz-Server-Side-Encryption-Context"))
+
+	content, err := fs.Read("copy.txt")
+	assert.Nil(t, err)
+	assert.Equal(t, "Hello", content)
+	assert.Equal(t, "", client.header("GetObject").Get("X-Amz-Server-Side-Encryption"))
+
+	err = fs.Write("large.txt", strings.Repeat("fly", int(s3manager.MinUploadPartSize)))
+	assert.Nil(t, err)
+
+	header = client.header("CreateMultipartUpload")
+	assert.Equal(t, EncryptionKMS, header.Get("X-Amz-Server-Side-Encryption"))
+	assert.Equal(t, context, header.Get("X-Amz-Server-Side-Encryption-Context"))
+	assert.Equal(t, "", client.header("UploadPart").Get("X-Amz-Server-Side-Encryption-Context"))
+}
+
+func TestCustomerKey(t *testing.T) {
+	bucket := client.createBucket("ssec")
+	key := []byte(strings.Repeat("k", 32))
+	sum := md5.Sum(key)
+	fs := NewAdapter(client, bucket, WithPartSize(1), WithDefaultEncryption(SSEC(key)))
+
+	err := fs.Write("secret.txt", "Hello")
+	assert.Nil(t, err)
+
+	header := client.header("PutObject")
+	assert.Equal(t, EncryptionAES256, header.Get("X-Amz-Server-Side-Encryption-Customer-Algorithm"))
+	assert.Equal(t, base64.StdEncoding.EncodeToString(key), header.Get("X-Amz-Server-Side-Encryption-Customer-Key"))
+	assert.Equal(t, base64.StdEncoding.EncodeToString(sum[:]), header.Get("X-Amz-Server-Side-Encryption-Customer-Key-Md5"))
+	assert.Equal(t, "", header.Get("X-Amz-Server-Side-Encryption"))
+
+	content, err := fs.Read("secret.txt")
+	assert.Nil(t, err)
+	assert.Equal(t, "Hello", content)
+
+	has, err := fs.Has("secret.txt")
+	assert.Nil(t, err)
+	assert.True(t, has)
+
+	err = fs.Copy("secret.txt", "copy.txt")
+	assert.Nil(t, err)
+	assert.Equal(t, base64.StdEncoding.EncodeToString(sum[:]), client.header("CopyObject").Get("X-Amz-Copy-Source-Server-Side-Encryption-Customer-Key-Md5"))
+
+	r, err := fs.ReadRange("copy.txt", 1, 3)
+	assert.Nil(t, err)
+	b, _ := ioutil.ReadAll(r)
+	assert.Equal(t, "ell", string(b))
+
+	large := strings.Repeat("fly", int(s3manager.MinUploadPartSize))
+	err = fs.Write("large.txt", large)
+	assert.Nil(t, err)
+
+	content, err = fs.Read("large.txt")
+	assert.Nil(t, err)
+	assert.True(t, content == large)
+
+	for _, other := range []*Adapter{
+		NewAdapter(client, bucket),
+		NewAdapter(client, bucket, WithDefaultEncryption(SSEC([]byte(strings.Repeat("x", 32))))),
+	} {
+		_, err = other.Read("secret.txt")
+		assert.NotNil(t, err)
+
+		_, err = other.Has("copy.txt")
+		assert.NotNil(t, err)
+
+		err = other.Copy("large.txt", "plain.txt")
+		assert.NotNil(t, err)
+	}
+}
+
+func TestClientSideEncryption(t *testing.T) {
+	bucket := client.createBucket("crypto")
+	fs := NewAdapter(client, bucket, WithClientSideEncryption(AESKeyWrap([]byte(strings.Repeat("m", 32)))))
+
+	err := fs.Write("secret.txt", "Hello, world!")
+	assert.Nil(t, err)
+
+	client.RLock()
+	stored := string(client.data[bucket]["secret.txt"])
+	metadata := client.metadata[bucket+"/secret.txt"]
+	client.RUnlock()
+
+	assert.Equal(t, 13+gcmTagSize, len(stored))
+	assert.False(t, strings.Contains(stored, "Hello"))
+	assert.Equal(t, AESGCMWrap, aws.StringValue(metadata["X-Amz-Wrap-Alg"]))
+	assert.Equal(t, "13", aws.StringValue(metadata[unencryptedLength]))
+
+	content, err := fs.Read("secret.txt")
+	assert.Nil(t, err)
+	assert.Equal(t, "Hello, world!", content)
+
+	r, err := fs.ReadRange("secret.txt", 7, 5)
+	assert.Nil(t, err)
+	b, _ := ioutil.ReadAll(r)
+	assert.Equal(t, "world", string(b))
+
+	buf := aws.NewWriteAtBuffer(nil)
+	n, err := fs.Download("secret.txt", buf)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(13), n)
+	assert.Equal(t, "Hello, world!", string(buf.Bytes()))
+
+	err = fs.Copy("secret.txt", "copy.txt")
+	assert.Nil(t, err)
+
+	content, err = fs.Read("copy.txt")
+	assert.Nil(t, err)
+	assert.Equal(t, "Hello, world!", content)
+
+	typ, err := fs.MimeType("copy.txt")
+	assert.Nil(t, err)
+	assert.Equal(t, "text/plain", typ)
+
+	info, err := fs.Stat("copy.txt")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(13), info.Size)
+
+	entries, err := fs.ListContents("", false)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(13), entries[0].Size)
+
+	err = fs.WriteStream("stream.txt", struct{ io.Reader }{strings.NewReader("stream")})
+	assert.Nil(t, err)
+
+	content, err = fs.Read("stream.txt")
+	assert.Nil(t, err)
+	assert.Equal(t, "stream", content)
+
+	err = fs.WriteNew("secret.txt", "Hello")
+	assert.True(t, errors.Is(err, adapter.ErrExist))
+
+	_, err = fs.TemporaryURL("secret.txt", time.Hour)
+	assert.True(t, errors.Is(err, adapter.ErrNotSupported))
+
+	_, err = fs.PostPolicy("", time.Hour, 0, 0)
+	assert.True(t, errors.Is(err, adapter.ErrNotSupported))
+
+	_, err = NewAdapter(client, bucket, WithClientSideEncryption(AESKeyWrap([]byte(strings.Repeat("x", 32))))).Read("secret.txt")
+	assert.NotNil(t, err)
+
+	_, err = NewAdapter(client, bucket, WithClientSideEncryption(KMSKeyWrap(&MockKMS{}, "key"))).Read("secret.txt")
 	assert.NotNil(t, err)
 }
 
-func TestFile(t *testing.T) {
-	fs := NewAdapter(client, "/tmp")
+func TestKMSKeyWrap(t *testing.T) {
+	kms := &MockKMS{}
+	fs := NewAdapter(client, client.createBucket("crypto-kms"), WithClientSideEncryption(KMSKeyWrap(kms, "key")))
+
+	err := fs.Write("secret.txt", "Hello, world!")
+	assert.Nil(t, err)
+
+	content, err := fs.Read("secret.txt")
+	assert.Nil(t, err)
+	assert.Equal(t, "Hello, world!", content)
+	assert.Equal(t, 1, kms.decrypted)
+}
+
+func TestPrefix(t *testing.T) {
+	bucket := client.createBucket("prefix")
+	fs := NewAdapter(client, bucket, WithPrefix("/service/a/"))
+	other := NewAdapter(client, bucket, WithPrefix("service/b"))
+	root := NewAdapter(client, bucket)
 
-	err := fs.Write("test/hello.txt", "Hello, world!")
+	err := fs.Write("dir/hello.txt", "Hello")
 	assert.Nil(t, err)
 
-	err = fs.Copy("test/hello.txt", "test/hello-copy.txt")
+	err = other.Write("hello.txt", "Other")
 	assert.Nil(t, err)
 
-	has, err := fs.Has("test/hello.txt")
+	content, err := root.Read("service/a/dir/hello.txt")
+	assert.Nil(t, err)
+	assert.Equal(t, "Hello", content)
+
+	has, err := other.Has("dir/hello.txt")
+	assert.Nil(t, err)
+	assert.False(t, has)
+
+	// Paths are cleaned, so they can't escape the prefix.
+	has, err = fs.Has("../b/hello.txt")
+	assert.Nil(t, err)
+	assert.False(t, has)
+
+	err = fs.Copy("dir/hello.txt", "dir/copy.txt")
+	assert.Nil(t, err)
+	assert.Equal(t, "prefix/service/a/dir/hello.txt", client.header("CopyObject").Get("X-Amz-Copy-Source"))
+
+	// The root would be the whole prefix.
+	for _, p := range []string{"", "/"} {
+		assert.True(t, errors.Is(fs.DeleteDir(p), adapter.ErrInvalidPath), p)
+		assert.True(t, errors.Is(fs.DeleteEmptyDir(p), adapter.ErrInvalidPath), p)
+	}
+
+	has, err = fs.Has("dir/hello.txt")
+	assert.Nil(t, err)
 	assert.True(t, has)
+
+	err = fs.CreateDir("empty")
 	assert.Nil(t, err)
 
-	content, err := fs.ReadAndDelete("test/hello-copy.txt")
+	has, err = fs.HasDir("empty")
 	assert.Nil(t, err)
-	assert.Equal(t, "Hello, world!", strings.TrimSpace(content))
+	assert.True(t, has)
 
-	has, err = fs.Has("test/hello-copy.txt")
+	entries, err := fs.ListContents("", true)
+	assert.Nil(t, err)
+	assert.Equal(t, 4, len(entries))
+	assert.Equal(t, "dir", entries[0].Path)
+	assert.Equal(t, "dir/copy.txt", entries[1].Path)
+	assert.Equal(t, "dir/hello.txt", entries[2].Path)
+	assert.Equal(t, "empty", entries[3].Path)
+
+	entries, err = fs.ListContents("dir", false)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(entries))
+	assert.Equal(t, "dir/copy.txt", entries[0].Path)
+
+	policy, err := fs.PostPolicy("uploads", time.Hour, 0, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, "service/a/uploads/${filename}", policy.Fields["key"])
+
+	client.Lock()
+	client.uploads["prefix-a"] = &MockUpload{bucket: bucket, key: "service/a/large.txt", initiated: time.Now().Add(-2 * time.Hour)}
+	client.uploads["prefix-b"] = &MockUpload{bucket: bucket, key: "service/b/large.txt", initiated: time.Now().Add(-2 * time.Hour)}
+	client.Unlock()
+
+	n, err := fs.AbortStaleUploads(time.Hour)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, n)
+
+	n, err = other.AbortStaleUploads(time.Hour)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, n)
+
+	err = fs.DeleteDir("dir")
+	assert.Nil(t, err)
+
+	content, err = other.Read("hello.txt")
+	assert.Nil(t, err)
+	assert.Equal(t, "Other", content)
+}
+
+func TestMarkerPolicy(t *testing.T) {
+	keys := func(bucket string) []string {
+		client.RLock()
+		defer client.RUnlock()
+		keys := []string{}
+		for key := range client.data[bucket] {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		return keys
+	}
+
+	bucket := client.createBucket("markers-always")
+	fs := NewAdapter(client, bucket)
+
+	assert.Nil(t, fs.CreateDir("a/b"))
+	assert.Nil(t, fs.CreateDir("a/b/c"))
+	assert.Nil(t, fs.Write("a/b/c/hello.txt", "Hello"))
+	assert.Nil(t, fs.Write("implied/hello.txt", "Hello"))
+	assert.Equal(t, []string{"a/b/", "a/b/c/", "a/b/c/hello.txt", "implied/hello.txt"}, keys(bucket))
+
+	has, err := fs.HasDir("implied")
+	assert.Nil(t, err)
+	assert.True(t, has)
+
+	has, err = fs.HasDir("a")
+	assert.Nil(t, err)
+	assert.True(t, has)
+
+	assert.Nil(t, fs.DeleteDir("a/b"))
+	assert.Equal(t, []string{"implied/hello.txt"}, keys(bucket))
+
+	bucket = client.createBucket("markers-never")
+	fs = NewAdapter(client, bucket, WithMarkerPolicy(MarkersNever))
+
+	assert.Nil(t, fs.CreateDir("a"))
+	assert.Nil(t, fs.Write("a/b/hello.txt", "Hello"))
+	assert.Equal(t, []string{"a/b/hello.txt"}, keys(bucket))
+
+	has, err = fs.HasDir("a/b")
+	assert.Nil(t, err)
+	assert.True(t, has)
+
+	assert.Nil(t, fs.Delete("a/b/hello.txt"))
+
+	has, err = fs.HasDir("a")
+	assert.Nil(t, err)
 	assert.False(t, has)
-	assert.NotNil(t, err)
+
+	bucket = client.createBucket("markers-empty")
+	fs = NewAdapter(client, bucket, WithMarkerPolicy(MarkersEmptyDirs))
+
+	assert.Nil(t, fs.CreateDir("a"))
+	assert.Nil(t, fs.CreateDir("a/b"))
+	assert.Equal(t, []string{"a/b/"}, keys(bucket))
+
+	assert.Nil(t, fs.Write("a/b/hello.txt", "Hello"))
+	assert.Equal(t, []string{"a/b/hello.txt"}, keys(bucket))
+
+	assert.Nil(t, fs.Copy("a/b/hello.txt", "a/c/copy.txt"))
+	assert.Nil(t, fs.Rename("a/b/hello.txt", "a/hello.txt"))
+	assert.Equal(t, []string{"a/b/", "a/c/copy.txt", "a/hello.txt"}, keys(bucket))
+
+	assert.Nil(t, fs.DeleteDir("a/c"))
+	assert.Nil(t, fs.DeleteEmptyDir("a/b"))
+	assert.Nil(t, fs.Delete("a/hello.txt"))
+	assert.Equal(t, []string{"a/"}, keys(bucket))
+
+	has, err = fs.HasDir("a")
+	assert.Nil(t, err)
+	assert.True(t, has)
 }
 
-func TestFileMimeType(t *testing.T) {
-	fs := NewAdapter(client, "/tmp")
+func TestKeys(t *testing.T) {
+	bucket := client.createBucket("keys")
+	fs := NewAdapter(client, bucket)
 
-	err := fs.Write("test/hello.txt", "Hello, world!")
+	err := fs.Write("a b+c#ü.txt", "Hello")
 	assert.Nil(t, err)
 
-	typ, err := fs.MimeType("test/hello.txt")
-	assert.Equal(t, "text/plain", typ)
+	err = fs.Copy("a b+c#ü.txt", "copy/a b+c#ü.txt")
+	assert.Nil(t, err)
+	assert.Equal(t, "keys/a%20b%2Bc%23%C3%BC.txt", client.header("CopyObject").Get("X-Amz-Copy-Source"))
+
+	content, err := fs.Read("copy/a b+c#ü.txt")
+	assert.Nil(t, err)
+	assert.Equal(t, "Hello", content)
+
+	for _, p := range []string{strings.Repeat("a", 1025), "a\nb.txt", "a\x7fb.txt", "a\xffb.txt"} {
+		err = fs.Write(p, "Hello")
+		assert.True(t, errors.Is(err, adapter.ErrInvalidPath), p)
+
+		err = fs.Copy("a b+c#ü.txt", p)
+		assert.True(t, errors.Is(err, adapter.ErrInvalidPath), p)
+
+		_, err = fs.TemporaryUploadURL(p, time.Minute)
+		assert.True(t, errors.Is(err, adapter.ErrInvalidPath), p)
+	}
+
+	// The prefix counts towards the length.
+	err = NewAdapter(client, bucket, WithPrefix("prefix")).Write(strings.Repeat("a", 1020), "Hello")
+	assert.True(t, errors.Is(err, adapter.ErrInvalidPath))
+
+	entries, err := fs.ListContents("", true)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(entries))
+
+	sanitized := NewAdapter(client, bucket, WithKeyPolicy(KeysSanitize))
+
+	err = sanitized.Write("a\nb\xff.txt", "Hello")
+	assert.Nil(t, err)
+
+	content, err = sanitized.Read("a\nb\xff.txt")
+	assert.Nil(t, err)
+	assert.Equal(t, "Hello", content)
+
+	has, err := fs.Has("a_b_.txt")
+	assert.Nil(t, err)
+	assert.True(t, has)
+
+	// Keys are truncated at a rune boundary.
+	long := "a" + strings.Repeat("é", 600)
+	err = sanitized.Write(long, "Hello")
+	assert.Nil(t, err)
+
+	has, err = fs.Has(long[:1023])
+	assert.Nil(t, err)
+	assert.True(t, has)
+
+	// Failing to delete the parent markers of the copy reports the copy.
+	err = NewAdapter(client, bucket, WithMarkerPolicy(MarkersEmptyDirs)).Copy("a b+c#ü.txt", "locked/copy.txt")
+	var pathErr *adapter.PathError
+	assert.True(t, errors.As(err, &pathErr))
+	assert.Equal(t, "locked/copy.txt", pathErr.Path)
+}
+
+func TestSuite(t *testing.T) {
+	n := 0
+
+	flytest.RunAdapterSuite(t, func() adapter.Adapter {
+		n++
+		return NewAdapter(client, client.createBucket(fmt.Sprintf("suite-%d", n)))
+	})
+
+	flytest.RunAdapterSuite(t, func() adapter.Adapter {
+		n++
+		return NewAdapter(client, client.createBucket(fmt.Sprintf("suite-%d", n)), WithClientSideEncryption(AESKeyWrap([]byte(strings.Repeat("m", 32)))))
+	})
+
+	flytest.RunAdapterSuite(t, func() adapter.Adapter {
+		n++
+		return NewAdapter(client, client.createBucket(fmt.Sprintf("suite-%d", n)), WithPrefix("prefix"))
+	})
+
+	flytest.RunAdapterSuite(t, func() adapter.Adapter {
+		n++
+		return NewAdapter(client, client.createBucket(fmt.Sprintf("suite-%d", n)), WithMarkerPolicy(MarkersEmptyDirs))
+	})
+}
+
+type MockKMS struct {
+	kmsiface.KMSAPI
+	sync.Mutex
+	keys      map[string][]byte
+	decrypted int
+}
+
+func (k *MockKMS) GenerateDataKey(input *kms.GenerateDataKeyInput) (*kms.GenerateDataKeyOutput, error) {
+	k.Lock()
+	defer k.Unlock()
+	if k.keys == nil {
+		k.keys = map[string][]byte{}
+	}
+	blob := fmt.Sprintf("%s-%d", aws.StringValue(input.KeyId), len(k.keys))
+	k.keys[blob] = bytes.Repeat([]byte{byte(len(k.keys))}, 32)
+	return &kms.GenerateDataKeyOutput{CiphertextBlob: []byte(blob), Plaintext: k.keys[blob]}, nil
+}
+
+func (k *MockKMS) Decrypt(input *kms.DecryptInput) (*kms.DecryptOutput, error) {
+	k.Lock()
+	defer k.Unlock()
+	key, ok := k.keys[string(input.CiphertextBlob)]
+	if !ok || !strings.HasPrefix(string(input.CiphertextBlob), aws.StringValue(input.EncryptionContext["kms_cmk_id"])+"-") {
+		return nil, awserr.New(kms.ErrCodeInvalidCiphertextException, "invalid ciphertext", nil)
+	}
+	k.decrypted++
+	return &kms.DecryptOutput{Plaintext: key}, nil
 }
 
 type MockBucket map[string][]byte
+type MockUpload struct {
+	bucket    string
+	key       string
+	initiated time.Time
+	parts     map[int64][]byte
+	sseKey    string
+}
 type MockS3 struct {
 	s3iface.S3API
 	sync.RWMutex
-	data map[string]MockBucket
+	data          map[string]MockBucket
+	acl           map[string]string
+	sseKeys       map[string]string
+	metadata      map[string]map[string]*string
+	headers       map[string]http.Header
+	uploads       map[string]*MockUpload
+	lastPut       *s3.PutObjectInput
+	lastComplete  *s3.CompleteMultipartUploadInput
+	ranges        []string
+	deleteBatches int
+}
+
+func (s *MockS3) createBucket(name string) string {
+	s.Lock()
+	defer s.Unlock()
+	s.data[name] = MockBucket{}
+	return name
+}
+
+// record builds the request with a real client, which doesn't send it,
+// and keeps its headers by operation. It must be called with the lock held.
+func (s *MockS3) record(req *request.Request, opts []request.Option) http.Header {
+	req.ApplyOptions(opts...)
+	req.Build()
+	if s.headers == nil {
+		s.headers = map[string]http.Header{}
+	}
+	s.headers[req.Operation.Name] = req.HTTPRequest.Header
+	return req.HTTPRequest.Header
+}
+
+func (s *MockS3) header(op string) http.Header {
+	s.RLock()
+	defer s.RUnlock()
+	return s.headers[op]
+}
+
+// checkCustomerKey fails unless the MD5 is of the customer key the object
+// was written with, or both are empty. It must be called with the lock held.
+func (s *MockS3) checkCustomerKey(bucket, key, sum string) error {
+	if s.sseKeys[bucket+"/"+key] != sum {
+		return ErrInvalidRequest
+	}
+	return nil
+}
+
+func (s *MockS3) setCustomerKey(bucket, key, sum string) {
+	if s.sseKeys == nil {
+		s.sseKeys = map[string]string{}
+	}
+	s.sseKeys[bucket+"/"+key] = sum
 }
 
-func (s *MockS3) PutObject(input *s3.PutObjectInput) (*s3.PutObjectOutput, error) {
+func (s *MockS3) CreateMultipartUploadWithContext(ctx aws.Context, input *s3.CreateMultipartUploadInput, opts ...request.Option) (*s3.CreateMultipartUploadOutput, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.Lock()
+	defer s.Unlock()
+	req, _ := requestClient.CreateMultipartUploadRequest(input)
+	h := s.record(req, opts)
+	if _, ok := s.data[*input.Bucket]; !ok {
+		return nil, ErrNoSuchBucket
+	}
+	id := fmt.Sprintf("upload-%d", len(s.uploads)+1)
+	for s.uploads[id] != nil {
+		id += "-"
+	}
+	s.uploads[id] = &MockUpload{
+		bucket:    *input.Bucket,
+		key:       *input.Key,
+		initiated: time.Now(),
+		parts:     map[int64][]byte{},
+		sseKey:    h.Get("X-Amz-Server-Side-Encryption-Customer-Key-Md5"),
+	}
+	if input.ACL != nil {
+		s.setACL(*input.Bucket, *input.Key, *input.ACL)
+	}
+	return &s3.CreateMultipartUploadOutput{UploadId: aws.String(id)}, nil
+}
+
+func (s *MockS3) UploadPartWithContext(ctx aws.Context, input *s3.UploadPartInput, opts ...request.Option) (*s3.UploadPartOutput, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	// Keys containing "broken" fail like a dropped connection.
+	if strings.Contains(*input.Key, "broken") && *input.PartNumber > 1 {
+		return nil, awserr.New(request.ErrCodeSerialization, "connection reset", nil)
+	}
+	content, _ := ioutil.ReadAll(input.Body)
+	s.Lock()
+	defer s.Unlock()
+	in := *input
+	in.Body = nil
+	req, _ := requestClient.UploadPartRequest(&in)
+	h := s.record(req, opts)
+	upload, ok := s.uploads[*input.UploadId]
+	if !ok {
+		return nil, awserr.New(s3.ErrCodeNoSuchUpload, "The specified upload does not exist.", nil)
+	}
+	if h.Get("X-Amz-Server-Side-Encryption-Customer-Key-Md5") != upload.sseKey {
+		return nil, ErrInvalidRequest
+	}
+	upload.parts[*input.PartNumber] = content
+	return &s3.UploadPartOutput{ETag: aws.String(fmt.Sprintf(`"%d"`, *input.PartNumber))}, nil
+}
+
+func (s *MockS3) CompleteMultipartUploadWithContext(ctx aws.Context, input *s3.CompleteMultipartUploadInput, opts ...request.Option) (*s3.CompleteMultipartUploadOutput, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.Lock()
+	defer s.Unlock()
+	upload, ok := s.uploads[*input.UploadId]
+	if !ok {
+		return nil, awserr.New(s3.ErrCodeNoSuchUpload, "The specified upload does not exist.", nil)
+	}
+	content := []byte{}
+	for _, part := range input.MultipartUpload.Parts {
+		content = append(content, upload.parts[*part.PartNumber]...)
+	}
+	s.data[upload.bucket][upload.key] = content
+	s.setCustomerKey(upload.bucket, upload.key, upload.sseKey)
+	s.lastComplete = input
+	delete(s.uploads, *input.UploadId)
+	return &s3.CompleteMultipartUploadOutput{Key: input.Key}, nil
+}
+
+func (s *MockS3) AbortMultipartUploadWithContext(ctx aws.Context, input *s3.AbortMultipartUploadInput, opts ...request.Option) (*s3.AbortMultipartUploadOutput, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.Lock()
+	defer s.Unlock()
+	if _, ok := s.uploads[*input.UploadId]; !ok {
+		return nil, awserr.New(s3.ErrCodeNoSuchUpload, "The specified upload does not exist.", nil)
+	}
+	delete(s.uploads, *input.UploadId)
+	return &s3.AbortMultipartUploadOutput{}, nil
+}
+
+func (s *MockS3) ListMultipartUploadsPagesWithContext(ctx aws.Context, input *s3.ListMultipartUploadsInput, fn func(*s3.ListMultipartUploadsOutput, bool) bool, opts ...request.Option) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.RLock()
+	output := &s3.ListMultipartUploadsOutput{}
+	for id, upload := range s.uploads {
+		if upload.bucket == *input.Bucket && strings.HasPrefix(upload.key, aws.StringValue(input.Prefix)) {
+			output.Uploads = append(output.Uploads, &s3.MultipartUpload{
+				Key:       aws.String(upload.key),
+				UploadId:  aws.String(id),
+				Initiated: aws.Time(upload.initiated),
+			})
+		}
+	}
+	s.RUnlock()
+	fn(output, true)
+	return nil
+}
+
+func (s *MockS3) PutObjectWithContext(ctx aws.Context, input *s3.PutObjectInput, opts ...request.Option) (*s3.PutObjectOutput, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 	s.Lock()
 	defer s.Unlock()
+	s.lastPut = input
+	in := *input
+	in.Body = nil
+	req, _ := requestClient.PutObjectRequest(&in)
+	h := s.record(req, opts)
 	content, _ := ioutil.ReadAll(input.Body)
-	if bucket, ok := s.data[*input.Bucket]; ok {
-		bucket[*input.Key] = content
-	} else {
+	bucket, ok := s.data[*input.Bucket]
+	if !ok {
 		return nil, ErrNoSuchBucket
 	}
+	object, exists := bucket[*input.Key]
+	if h.Get("If-None-Match") == "*" && exists {
+		return nil, ErrPreconditionFailed
+	}
+	if etag := h.Get("If-Match"); etag != "" {
+		if !exists {
+			return nil, ErrNoSuchKey
+		}
+		if sum := md5.Sum(object); etag != `"`+hex.EncodeToString(sum[:])+`"` {
+			return nil, ErrPreconditionFailed
+		}
+	}
+	bucket[*input.Key] = content
+	s.setCustomerKey(*input.Bucket, *input.Key, h.Get("X-Amz-Server-Side-Encryption-Customer-Key-Md5"))
+	s.setMetadata(*input.Bucket, *input.Key, input.Metadata)
+	if input.ACL != nil {
+		s.setACL(*input.Bucket, *input.Key, *input.ACL)
+	}
 	return &s3.PutObjectOutput{
 		ETag: input.Key,
 	}, nil
 }
 
-func (s *MockS3) CopyObject(input *s3.CopyObjectInput) (*s3.CopyObjectOutput, error) {
+func (s *MockS3) setACL(bucket, key, acl string) {
+	if s.acl == nil {
+		s.acl = map[string]string{}
+	}
+	s.acl[bucket+"/"+key] = acl
+}
+
+func (s *MockS3) PutObjectAclWithContext(ctx aws.Context, input *s3.PutObjectAclInput, opts ...request.Option) (*s3.PutObjectAclOutput, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.Lock()
+	defer s.Unlock()
+	if _, ok := s.data[*input.Bucket][*input.Key]; !ok {
+		return nil, ErrNoSuchKey
+	}
+	s.setACL(*input.Bucket, *input.Key, aws.StringValue(input.ACL))
+	return &s3.PutObjectAclOutput{}, nil
+}
+
+func (s *MockS3) GetObjectAclWithContext(ctx aws.Context, input *s3.GetObjectAclInput, opts ...request.Option) (*s3.GetObjectAclOutput, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.RLock()
+	defer s.RUnlock()
+	if _, ok := s.data[*input.Bucket][*input.Key]; !ok {
+		return nil, ErrNoSuchKey
+	}
+	output := &s3.GetObjectAclOutput{Grants: []*s3.Grant{{
+		Grantee:    &s3.Grantee{ID: aws.String("owner"), Type: aws.String(s3.TypeCanonicalUser)},
+		Permission: aws.String(s3.PermissionFullControl),
+	}}}
+	if s.acl[*input.Bucket+"/"+*input.Key] == s3.ObjectCannedACLPublicRead {
+		output.Grants = append(output.Grants, &s3.Grant{
+			Grantee:    &s3.Grantee{URI: aws.String(allUsersURI), Type: aws.String(s3.TypeGroup)},
+			Permission: aws.String(s3.PermissionRead),
+		})
+	}
+	return output, nil
+}
+
+// send replaces the handlers that send the request with the mock, fn is called
+// with a option that copies the headers of the request and returns the response.
+// It's used to send the requests of the s3crypto clients.
+func (s *MockS3) send(req *request.Request, fn func(opt request.Option) (*http.Response, error)) {
+	req.Handlers.Send.Clear()
+	req.Handlers.Send.PushBack(func(r *request.Request) {
+		res, err := fn(func(r2 *request.Request) {
+			for k, v := range r.HTTPRequest.Header {
+				r2.HTTPRequest.Header[k] = v
+			}
+		})
+		if err != nil {
+			r.Error = err
+			r.Retryable = aws.Bool(false)
+			res = &http.Response{StatusCode: 400, Header: http.Header{}, Body: ioutil.NopCloser(strings.NewReader(""))}
+			if rerr, ok := err.(awserr.RequestFailure); ok {
+				res.StatusCode = rerr.StatusCode()
+			}
+		}
+		r.HTTPResponse = res
+	})
+}
+
+func (s *MockS3) setMetadata(bucket, key string, metadata map[string]*string) {
+	if s.metadata == nil {
+		s.metadata = map[string]map[string]*string{}
+	}
+	s.metadata[bucket+"/"+key] = metadata
+}
+
+// GetObjectRequest builds the request with a real client, which is sent to the mock.
+func (s *MockS3) GetObjectRequest(input *s3.GetObjectInput) (*request.Request, *s3.GetObjectOutput) {
+	req, output := requestClient.GetObjectRequest(input)
+	s.send(req, func(opt request.Option) (*http.Response, error) {
+		res, err := s.GetObjectWithContext(req.Context(), input, opt)
+		if err != nil {
+			return nil, err
+		}
+		header := http.Header{}
+		header.Set("Content-Length", fmt.Sprint(aws.Int64Value(res.ContentLength)))
+		for k, v := range res.Metadata {
+			header.Set("X-Amz-Meta-"+k, aws.StringValue(v))
+		}
+		return &http.Response{StatusCode: 200, Header: header, Body: res.Body}, nil
+	})
+	return req, output
+}
+
+func (s *MockS3) HeadBucketRequest(input *s3.HeadBucketInput) (*request.Request, *s3.HeadBucketOutput) {
+	return requestClient.HeadBucketRequest(input)
+}
+
+func (s *MockS3) PutObjectRequest(input *s3.PutObjectInput) (*request.Request, *s3.PutObjectOutput) {
+	req, output := requestClient.PutObjectRequest(input)
+	s.send(req, func(opt request.Option) (*http.Response, error) {
+		// The encryption client replaces the body and adds the envelope to the metadata.
+		res, err := s.PutObjectWithContext(req.Context(), req.Params.(*s3.PutObjectInput), opt)
+		if err != nil {
+			return nil, err
+		}
+		header := http.Header{"Etag": []string{aws.StringValue(res.ETag)}}
+		return &http.Response{StatusCode: 200, Header: header, Body: ioutil.NopCloser(strings.NewReader(""))}, nil
+	})
+	return req, output
+}
+
+func (s *MockS3) CopyObjectWithContext(ctx aws.Context, input *s3.CopyObjectInput, opts ...request.Option) (*s3.CopyObjectOutput, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 	s.Lock()
 	defer s.Unlock()
+	req, _ := requestClient.CopyObjectRequest(input)
+	h := s.record(req, opts)
 	bucket := s.data[*input.Bucket]
-	p := strings.Split(*input.CopySource, "/")
-	src, ok := bucket[strings.Join(p[2:], "/")]
+	source, err := url.PathUnescape(*input.CopySource)
+	if err != nil {
+		return nil, err
+	}
+	key := strings.TrimPrefix(source, *input.Bucket+"/")
+	src, ok := bucket[key]
 	if !ok {
-		return nil, ErrMisingKey
+		return nil, ErrNoSuchKey
+	}
+	if err := s.checkCustomerKey(*input.Bucket, key, h.Get("X-Amz-Copy-Source-Server-Side-Encryption-Customer-Key-Md5")); err != nil {
+		return nil, err
 	}
 
 	bucket[*input.Key] = src
+	s.setCustomerKey(*input.Bucket, *input.Key, h.Get("X-Amz-Server-Side-Encryption-Customer-Key-Md5"))
+	s.setMetadata(*input.Bucket, *input.Key, s.metadata[*input.Bucket+"/"+key])
 
 	return &s3.CopyObjectOutput{}, nil
 }
 
-func (s *MockS3) DeleteObject(input *s3.DeleteObjectInput) (*s3.DeleteObjectOutput, error) {
+func (s *MockS3) DeleteObjectWithContext(ctx aws.Context, input *s3.DeleteObjectInput, opts ...request.Option) (*s3.DeleteObjectOutput, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 	s.Lock()
 	defer s.Unlock()
 	bucket := s.data[*input.Bucket]
@@ -117,32 +1344,172 @@ func (s *MockS3) DeleteObject(input *s3.DeleteObjectInput) (*s3.DeleteObjectOutp
 	return &s3.DeleteObjectOutput{}, nil
 }
 
-func (s *MockS3) HeadObject(input *s3.HeadObjectInput) (*s3.HeadObjectOutput, error) {
+func (s *MockS3) HeadObjectWithContext(ctx aws.Context, input *s3.HeadObjectInput, opts ...request.Option) (*s3.HeadObjectOutput, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 	s.Lock()
 	defer s.Unlock()
-	bucket := s.data[*input.Bucket]
-	if _, ok := bucket[*input.Key]; !ok {
-		return nil, ErrMisingKey
+	req, _ := requestClient.HeadObjectRequest(input)
+	h := s.record(req, opts)
+	bucket, ok := s.data[*input.Bucket]
+	if !ok {
+		return nil, ErrNoSuchBucket
+	}
+	object, ok := bucket[*input.Key]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	if err := s.checkCustomerKey(*input.Bucket, *input.Key, h.Get("X-Amz-Server-Side-Encryption-Customer-Key-Md5")); err != nil {
+		return nil, err
 	}
 	var c string
 	if strings.HasSuffix(*input.Key, "txt") {
 		c = "text/plain"
 	}
+	sum := md5.Sum(object)
 	return &s3.HeadObjectOutput{
-		ContentType: &c,
+		ContentType:   &c,
+		ContentLength: aws.Int64(int64(len(object))),
+		ETag:          aws.String(`"` + hex.EncodeToString(sum[:]) + `"`),
+		LastModified:  aws.Time(mockTime),
+		Metadata:      s.metadata[*input.Bucket+"/"+*input.Key],
 	}, nil
 }
 
-func (s *MockS3) GetObject(input *s3.GetObjectInput) (*s3.GetObjectOutput, error) {
+func (s *MockS3) GetObjectWithContext(ctx aws.Context, input *s3.GetObjectInput, opts ...request.Option) (*s3.GetObjectOutput, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.Lock()
+	defer s.Unlock()
+	req, _ := requestClient.GetObjectRequest(input)
+	h := s.record(req, opts)
+	bucket := s.data[*input.Bucket]
+	object, ok := bucket[*input.Key]
+	if !ok {
+		return nil, ErrNoSuchKey
+	}
+	if err := s.checkCustomerKey(*input.Bucket, *input.Key, h.Get("X-Amz-Server-Side-Encryption-Customer-Key-Md5")); err != nil {
+		return nil, err
+	}
+	output := &s3.GetObjectOutput{
+		ContentLength: aws.Int64(int64(len(object))),
+		Metadata:      s.metadata[*input.Bucket+"/"+*input.Key],
+	}
+	if input.Range != nil {
+		s.ranges = append(s.ranges, *input.Range)
+		var start, end int64
+		if n, _ := fmt.Sscanf(*input.Range, "bytes=%d-%d", &start, &end); n < 2 || end >= int64(len(object)) {
+			end = int64(len(object)) - 1
+		}
+		if start >= int64(len(object)) {
+			return nil, ErrInvalidRange
+		}
+		object = object[start : end+1]
+		output.ContentLength = aws.Int64(int64(len(object)))
+		output.ContentRange = aws.String(fmt.Sprintf("bytes %d-%d/%d", start, end, len(bucket[*input.Key])))
+	}
+	output.Body = ioutil.NopCloser(bytes.NewReader(object))
+	return output, nil
+}
+
+func (s *MockS3) ListObjectsV2WithContext(ctx aws.Context, input *s3.ListObjectsV2Input, opts ...request.Option) (*s3.ListObjectsV2Output, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 	s.RLock()
 	defer s.RUnlock()
+	bucket, ok := s.data[*input.Bucket]
+	if !ok {
+		return nil, ErrNoSuchBucket
+	}
+
+	prefix := aws.StringValue(input.Prefix)
+	delimiter := aws.StringValue(input.Delimiter)
+	token := aws.StringValue(input.ContinuationToken)
+	maxKeys := aws.Int64Value(input.MaxKeys)
+	if maxKeys == 0 {
+		maxKeys = 1000
+	}
+
+	keys := []string{}
+	for key := range bucket {
+		if strings.HasPrefix(key, prefix) && key > token {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	output := &s3.ListObjectsV2Output{IsTruncated: aws.Bool(false)}
+	count := int64(0)
+	for _, key := range keys {
+		// Skip the keys of a common prefix that's already returned.
+		if key <= aws.StringValue(output.NextContinuationToken) {
+			continue
+		}
+		if count == maxKeys {
+			output.IsTruncated = aws.Bool(true)
+			break
+		}
+		rest := strings.TrimPrefix(key, prefix)
+		if i := strings.Index(rest, delimiter); delimiter != "" && i >= 0 {
+			p := prefix + rest[:i+1]
+			output.CommonPrefixes = append(output.CommonPrefixes, &s3.CommonPrefix{Prefix: aws.String(p)})
+			output.NextContinuationToken = aws.String(p + "\xff")
+			count++
+			continue
+		}
+		output.Contents = append(output.Contents, &s3.Object{
+			Key:          aws.String(key),
+			Size:         aws.Int64(int64(len(bucket[key]))),
+			LastModified: aws.Time(mockTime),
+		})
+		output.NextContinuationToken = aws.String(key)
+		count++
+	}
+
+	return output, nil
+}
+
+func (s *MockS3) ListObjectsV2PagesWithContext(ctx aws.Context, input *s3.ListObjectsV2Input, fn func(*s3.ListObjectsV2Output, bool) bool, opts ...request.Option) error {
+	in := *input
+	for {
+		page, err := s.ListObjectsV2WithContext(ctx, &in)
+		if err != nil {
+			return err
+		}
+		last := !aws.BoolValue(page.IsTruncated)
+		if !fn(page, last) || last {
+			return nil
+		}
+		in.ContinuationToken = page.NextContinuationToken
+	}
+}
+
+func (s *MockS3) DeleteObjectsWithContext(ctx aws.Context, input *s3.DeleteObjectsInput, opts ...request.Option) (*s3.DeleteObjectsOutput, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if len(input.Delete.Objects) > 1000 {
+		return nil, awserr.New("MalformedXML", "Too many keys", nil)
+	}
+	s.Lock()
+	defer s.Unlock()
+	s.deleteBatches++
 	bucket := s.data[*input.Bucket]
-	if object, ok := bucket[*input.Key]; ok {
-		body := ioutil.NopCloser(bytes.NewReader(object))
-		output := s3.GetObjectOutput{
-			Body: body,
+	output := &s3.DeleteObjectsOutput{}
+	for _, obj := range input.Delete.Objects {
+		// Keys containing "locked" fail like keys protected by a bucket policy.
+		if strings.Contains(*obj.Key, "locked") {
+			output.Errors = append(output.Errors, &s3.Error{
+				Key:     obj.Key,
+				Code:    aws.String("AccessDenied"),
+				Message: aws.String("Access Denied"),
+			})
+			continue
 		}
-		return &output, nil
+		delete(bucket, *obj.Key)
 	}
-	return nil, ErrMisingKey
+	return output, nil
 }
@@ -0,0 +1,100 @@
+package flys3
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/frozzare/go-fly/adapter"
+)
+
+// maxKeyLength is the maximum size of a AWS S3 key in bytes.
+const maxKeyLength = 1024
+
+// KeyPolicy represents how the adapter handles paths that AWS S3 can't store,
+// which are keys longer than 1024 bytes, with control characters or that
+// aren't valid UTF-8. The prefix counts towards the length.
+type KeyPolicy int
+
+// Key policies.
+const (
+	// KeysReject fails writes of invalid keys with adapter.ErrInvalidPath.
+	KeysReject KeyPolicy = iota
+
+	// KeysSanitize replaces control characters and invalid UTF-8 with "_" and
+	// truncates keys to 1024 bytes, for reads as well as writes. Different
+	// paths may end up as the same key.
+	KeysSanitize
+)
+
+// WithKeyPolicy sets how invalid keys are handled, the default is KeysReject.
+func WithKeyPolicy(p KeyPolicy) Option {
+	return func(a *Adapter) {
+		a.keys = p
+	}
+}
+
+// validKey returns whether AWS S3 can store the key.
+func validKey(key string) bool {
+	if len(key) > maxKeyLength || !utf8.ValidString(key) {
+		return false
+	}
+
+	return strings.IndexFunc(key, unicode.IsControl) == -1
+}
+
+// sanitizeKey replaces what AWS S3 can't store in the key with "_"
+// and truncates it to the maximum length at a rune boundary.
+func sanitizeKey(key string) string {
+	key = strings.Map(func(r rune) rune {
+		if r == utf8.RuneError || unicode.IsControl(r) {
+			return '_'
+		}
+
+		return r
+	}, key)
+
+	if len(key) > maxKeyLength {
+		n := maxKeyLength
+		for n > 0 && !utf8.RuneStart(key[n]) {
+			n--
+		}
+
+		key = key[:n]
+	}
+
+	return key
+}
+
+// checkKey returns adapter.ErrInvalidPath when the key of the path
+// can't be stored and invalid keys are rejected.
+func (a *Adapter) checkKey(path string) error {
+	if a.keys == KeysReject && !validKey(a.key(path)) {
+		return adapter.ErrInvalidPath
+	}
+
+	return nil
+}
+
+// copySource returns the URL-encoded copy source of the path, AWS S3 decodes
+// it, so everything but unreserved characters and slashes is escaped.
+func (a *Adapter) copySource(path string) string {
+	source := a.bucket + "/" + a.key(path)
+
+	var b strings.Builder
+	for i := 0; i < len(source); i++ {
+		c := source[i]
+
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+			b.WriteByte(c)
+		case strings.IndexByte("-_.~/", c) != -1:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+
+	return b.String()
+}
@@ -0,0 +1,56 @@
+package flys3
+
+import (
+	"path"
+	"strings"
+)
+
+// WithPrefix scopes the adapter to the keys under the prefix, like a directory
+// in the bucket, so buckets can be shared. Paths are relative to the prefix and
+// are cleaned, so they can't escape it with "..".
+func WithPrefix(prefix string) Option {
+	return func(a *Adapter) {
+		a.prefix = strings.Trim(prefix, "/")
+
+		if a.prefix != "" {
+			a.prefix += "/"
+		}
+	}
+}
+
+// key returns the key of the path under the prefix, which is sanitized
+// with KeysSanitize. The trailing slash of a directory marker is kept
+// when the path is cleaned.
+func (a *Adapter) key(p string) string {
+	key := p
+
+	if a.prefix != "" {
+		clean := strings.TrimLeft(path.Clean("/"+p), "/")
+		if clean != "" && strings.HasSuffix(p, "/") {
+			clean += "/"
+		}
+
+		key = a.prefix + clean
+	}
+
+	if a.keys == KeysSanitize {
+		key = sanitizeKey(key)
+	}
+
+	return key
+}
+
+// dirKey returns the key prefix of the keys in the directory.
+func (a *Adapter) dirKey(dir string) string {
+	dir = strings.Trim(dir, "/")
+	if dir != "" {
+		dir += "/"
+	}
+
+	return a.key(dir)
+}
+
+// relative returns the path of the key relative to the prefix.
+func (a *Adapter) relative(key string) string {
+	return strings.TrimPrefix(key, a.prefix)
+}
@@ -0,0 +1,208 @@
+package flys3
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/frozzare/go-fly/adapter"
+)
+
+// TemporaryURL will return a presigned URL to download a file on AWS S3 until
+// the expiry, which is at most 7 days. The content type and disposition options
+// override the headers of the response, e.g. to download a file as an attachment.
+// It fails with adapter.ErrNotSupported with client-side encryption.
+func (a *Adapter) TemporaryURL(path string, expiry time.Duration, opts ...adapter.Option) (string, error) {
+	if err := a.errEncrypted(); err != nil {
+		return "", wrapError("temporaryurl", path, err)
+	}
+
+	cfg := adapter.NewConfig(opts...)
+
+	input := a.getObjectInput(path)
+
+	if cfg.ContentType != "" {
+		input.ResponseContentType = aws.String(cfg.ContentType)
+	}
+
+	if cfg.ContentDisposition != "" {
+		input.ResponseContentDisposition = aws.String(cfg.ContentDisposition)
+	}
+
+	req, _ := a.s3.GetObjectRequest(input)
+	u, err := req.Presign(expiry)
+
+	return u, wrapError("temporaryurl", path, err)
+}
+
+// TemporaryUploadURL will return a presigned URL to upload a file to AWS S3 with
+// a PUT request until the expiry, which is at most 7 days. The options are signed
+// headers, so the request must send them as well. That includes the content type,
+// which is guessed from the extension when not set. It fails with
+// adapter.ErrNotSupported with client-side encryption.
+func (a *Adapter) TemporaryUploadURL(path string, expiry time.Duration, opts ...adapter.Option) (string, error) {
+	if err := a.errEncrypted(); err != nil {
+		return "", wrapError("temporaryuploadurl", path, err)
+	}
+
+	if err := a.checkKey(path); err != nil {
+		return "", wrapError("temporaryuploadurl", path, err)
+	}
+
+	req, _ := a.s3.PutObjectRequest(a.putObjectInput(path, nil, adapter.NewConfig(opts...)))
+	u, err := req.Presign(expiry)
+
+	return u, wrapError("temporaryuploadurl", path, err)
+}
+
+// PostPolicy will return a policy signed with AWS Signature Version 4 for
+// browsers to upload a file to the directory on AWS S3 with a HTML form until
+// the expiry. AWS S3 replaces ${filename} in the key field with the name of the
+// uploaded file, the form may change the key as long as it's in the directory.
+// The size is checked with a content-length-range condition and the options
+// are form fields that must match exactly, like the fields of the server-side
+// encryption. It fails with adapter.ErrNotSupported with client-side encryption
+// and with a customer key, which the form would hand to the browser.
+func (a *Adapter) PostPolicy(dir string, expiry time.Duration, minSize, maxSize int64, opts ...adapter.Option) (adapter.PostPolicy, error) {
+	if err := a.errEncrypted(); err != nil {
+		return adapter.PostPolicy{}, wrapError("postpolicy", dir, err)
+	}
+
+	cfg := adapter.NewConfig(opts...)
+	if a.sse(cfg.Encryption).customerKey != nil {
+		return adapter.PostPolicy{}, wrapError("postpolicy", dir, adapter.ErrNotSupported)
+	}
+
+	prefix := a.dirKey(dir)
+
+	// The request is only built to get the bucket URL and the credentials.
+	req, _ := a.s3.HeadBucketRequest(&s3.HeadBucketInput{Bucket: aws.String(a.bucket)})
+	if err := req.Build(); err != nil {
+		return adapter.PostPolicy{}, wrapError("postpolicy", dir, err)
+	}
+
+	if req.Config.Credentials == nil {
+		return adapter.PostPolicy{}, wrapError("postpolicy", dir, errors.New("no credentials to sign the policy"))
+	}
+
+	creds, err := req.Config.Credentials.Get()
+	if err != nil {
+		return adapter.PostPolicy{}, wrapError("postpolicy", dir, err)
+	}
+
+	region := req.ClientInfo.SigningRegion
+	if region == "" {
+		region = aws.StringValue(req.Config.Region)
+	}
+
+	now := time.Now().UTC()
+	date := now.Format("20060102")
+
+	fields := a.postFields(cfg)
+	fields["x-amz-algorithm"] = "AWS4-HMAC-SHA256"
+	fields["x-amz-credential"] = strings.Join([]string{creds.AccessKeyID, date, region, "s3", "aws4_request"}, "/")
+	fields["x-amz-date"] = now.Format("20060102T150405Z")
+
+	if creds.SessionToken != "" {
+		fields["x-amz-security-token"] = creds.SessionToken
+	}
+
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	conditions := []interface{}{
+		map[string]string{"bucket": a.bucket},
+		[]string{"starts-with", "$key", prefix},
+	}
+
+	for _, name := range names {
+		conditions = append(conditions, map[string]string{name: fields[name]})
+	}
+
+	if maxSize > 0 {
+		conditions = append(conditions, []interface{}{"content-length-range", minSize, maxSize})
+	}
+
+	policy, err := json.Marshal(map[string]interface{}{
+		"expiration": now.Add(expiry).Format("2006-01-02T15:04:05.000Z"),
+		"conditions": conditions,
+	})
+
+	if err != nil {
+		return adapter.PostPolicy{}, wrapError("postpolicy", dir, err)
+	}
+
+	fields["key"] = prefix + "${filename}"
+	fields["policy"] = base64.StdEncoding.EncodeToString(policy)
+
+	key := []byte("AWS4" + creds.SecretAccessKey)
+	for _, data := range []string{date, region, "s3", "aws4_request", fields["policy"]} {
+		key = hmacSHA256(key, data)
+	}
+
+	fields["x-amz-signature"] = hex.EncodeToString(key)
+
+	return adapter.PostPolicy{URL: req.HTTPRequest.URL.String(), Fields: fields}, nil
+}
+
+// postFields maps the config onto the form fields of a POST policy,
+// with the server-side encryption of the config or else the default.
+func (a *Adapter) postFields(cfg *adapter.Config) map[string]string {
+	fields := map[string]string{}
+	e := a.sse(cfg.Encryption)
+
+	if e.algorithm != nil {
+		fields["x-amz-server-side-encryption"] = *e.algorithm
+	}
+
+	if e.kmsKeyID != nil {
+		fields["x-amz-server-side-encryption-aws-kms-key-id"] = *e.kmsKeyID
+	}
+
+	if context := a.encodedContext(cfg.Encryption); context != "" {
+		fields["x-amz-server-side-encryption-context"] = context
+	}
+
+	if cfg.ContentType != "" {
+		fields["Content-Type"] = cfg.ContentType
+	}
+
+	if cfg.CacheControl != "" {
+		fields["Cache-Control"] = cfg.CacheControl
+	}
+
+	if cfg.ContentDisposition != "" {
+		fields["Content-Disposition"] = cfg.ContentDisposition
+	}
+
+	for k, v := range cfg.Metadata {
+		fields["x-amz-meta-"+k] = v
+	}
+
+	switch cfg.Visibility {
+	case adapter.VisibilityPublic:
+		fields["acl"] = s3.ObjectCannedACLPublicRead
+	case adapter.VisibilityPrivate:
+		fields["acl"] = s3.ObjectCannedACLPrivate
+	}
+
+	return fields
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
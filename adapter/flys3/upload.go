@@ -0,0 +1,144 @@
+package flys3
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awsutil"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/frozzare/go-fly/adapter"
+)
+
+// upload uploads the reader with a single PutObject request when it fits in a
+// part and with a multipart upload otherwise. Readers that can't seek are
+// buffered up to a part to find out which.
+func (a *Adapter) upload(path string, r io.Reader, cfg *adapter.Config) error {
+	if a.encrypter != nil {
+		body, err := seekable(r)
+		if err != nil {
+			return err
+		}
+
+		return a.put(path, body, cfg)
+	}
+
+	if body, ok := r.(io.ReadSeeker); ok {
+		if size, err := remaining(body); err == nil {
+			if size <= a.partSize {
+				return a.put(path, body, cfg)
+			}
+
+			return a.multipart(path, body, cfg)
+		}
+	}
+
+	buf := &bytes.Buffer{}
+
+	_, err := io.CopyN(buf, r, a.partSize+1)
+	if err == io.EOF {
+		return a.put(path, bytes.NewReader(buf.Bytes()), cfg)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return a.multipart(path, io.MultiReader(buf, r), cfg)
+}
+
+// remaining returns the number of bytes left to read from the body.
+func remaining(body io.Seeker) (int64, error) {
+	pos, err := body.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, err
+	}
+
+	end, err := body.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, err
+	}
+
+	if _, err := body.Seek(pos, io.SeekStart); err != nil {
+		return 0, err
+	}
+
+	return end - pos, nil
+}
+
+// multipart uploads the body in parts with s3manager.Uploader.
+func (a *Adapter) multipart(path string, body io.Reader, cfg *adapter.Config) error {
+	if err := a.checkKey(path); err != nil {
+		return err
+	}
+
+	input := &s3manager.UploadInput{}
+	awsutil.Copy(input, a.putObjectInput(path, nil, cfg))
+	input.Body = body
+
+	uploader := s3manager.NewUploaderWithClient(a.s3, func(u *s3manager.Uploader) {
+		u.PartSize = a.partSize
+		u.Concurrency = a.concurrency
+		u.RequestOptions = a.encryptionContext(cfg.Encryption)
+
+		// The uploader aborts with the context of the upload,
+		// which fails when it's canceled, so it's done here.
+		u.LeavePartsOnError = true
+	})
+
+	_, err := uploader.UploadWithContext(a.ctx, input)
+	if merr, ok := err.(s3manager.MultiUploadFailure); ok && merr.UploadID() != "" {
+		a.abort(context.WithoutCancel(a.ctx), aws.StringValue(input.Key), merr.UploadID())
+	}
+
+	return err
+}
+
+// abort aborts the multipart upload, which deletes the uploaded parts.
+func (a *Adapter) abort(ctx context.Context, key, uploadID string) error {
+	_, err := a.s3.AbortMultipartUploadWithContext(ctx, &s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(a.bucket),
+		Key:      aws.String(key),
+		UploadId: aws.String(uploadID),
+	})
+
+	return err
+}
+
+// AbortStaleUploads aborts the incomplete multipart uploads under the prefix that
+// were started longer ago than the age, like uploads left behind by a crash. AWS
+// S3 keeps the parts of incomplete uploads, and charges for them, until they're
+// aborted. It returns the number of aborted uploads.
+func (a *Adapter) AbortStaleUploads(age time.Duration) (int, error) {
+	aborted := 0
+	before := time.Now().Add(-age)
+
+	var abortErr error
+	err := a.s3.ListMultipartUploadsPagesWithContext(a.ctx, &s3.ListMultipartUploadsInput{
+		Bucket: aws.String(a.bucket),
+		Prefix: aws.String(a.prefix),
+	}, func(page *s3.ListMultipartUploadsOutput, last bool) bool {
+		for _, upload := range page.Uploads {
+			if !aws.TimeValue(upload.Initiated).Before(before) {
+				continue
+			}
+
+			if abortErr = a.abort(a.ctx, aws.StringValue(upload.Key), aws.StringValue(upload.UploadId)); abortErr != nil {
+				return false
+			}
+
+			aborted++
+		}
+
+		return true
+	})
+
+	if err == nil {
+		err = abortErr
+	}
+
+	return aborted, wrapError("abortuploads", "", err)
+}
@@ -0,0 +1,73 @@
+package fly
+
+import "github.com/frozzare/go-fly/adapter"
+
+// Capabilities reports which optional interfaces the adapter implements
+// natively, interfaces that adapter.Emulator lists aren't reported. Features
+// the adapter lacks are emulated by the filesystem where possible, e.g. Copy
+// reads and writes the file, otherwise ErrNotSupported is returned.
+type Capabilities struct {
+	ConditionalWriter     bool
+	Context               bool
+	EmptyDirDeleter       bool
+	Lister                bool
+	PostPolicyGenerator   bool
+	RangeReader           bool
+	Renamer               bool
+	ServerSideCopier      bool
+	Stater                bool
+	Streamer              bool
+	TemporaryURLGenerator bool
+	URLGenerator          bool
+	Visibility            bool
+}
+
+// Capabilities will return the features the adapter supports natively.
+func (f *Filesystem) Capabilities() Capabilities {
+	var c Capabilities
+
+	_, c.ConditionalWriter = f.adapter.(adapter.ConditionalWriter)
+	_, c.Context = f.adapter.(adapter.ContextAdapter)
+	_, c.EmptyDirDeleter = f.adapter.(adapter.EmptyDirDeleter)
+	_, c.Lister = f.adapter.(adapter.Lister)
+	_, c.PostPolicyGenerator = f.adapter.(adapter.PostPolicyGenerator)
+	_, c.RangeReader = f.adapter.(adapter.RangeReader)
+	_, c.Renamer = f.adapter.(adapter.Renamer)
+	_, c.ServerSideCopier = f.adapter.(adapter.ServerSideCopier)
+	_, c.Stater = f.adapter.(adapter.Stater)
+	_, c.Streamer = f.adapter.(adapter.Streamer)
+	_, c.TemporaryURLGenerator = f.adapter.(adapter.TemporaryURLGenerator)
+	_, c.URLGenerator = f.adapter.(adapter.URLGenerator)
+	_, c.Visibility = f.adapter.(adapter.Visibility)
+
+	if e, ok := f.adapter.(adapter.Emulator); ok {
+		c.emulated(e.Emulated())
+	}
+
+	return c
+}
+
+// emulated unsets the capabilities of the emulated interfaces.
+func (c *Capabilities) emulated(names []string) {
+	fields := map[string]*bool{
+		"ConditionalWriter":     &c.ConditionalWriter,
+		"Context":               &c.Context,
+		"EmptyDirDeleter":       &c.EmptyDirDeleter,
+		"Lister":                &c.Lister,
+		"PostPolicyGenerator":   &c.PostPolicyGenerator,
+		"RangeReader":           &c.RangeReader,
+		"Renamer":               &c.Renamer,
+		"ServerSideCopier":      &c.ServerSideCopier,
+		"Stater":                &c.Stater,
+		"Streamer":              &c.Streamer,
+		"TemporaryURLGenerator": &c.TemporaryURLGenerator,
+		"URLGenerator":          &c.URLGenerator,
+		"Visibility":            &c.Visibility,
+	}
+
+	for _, name := range names {
+		if b, ok := fields[name]; ok {
+			*b = false
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package fly
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/frozzare/go-fly/adapter"
+)
+
+// Context returns the filesystem context.
+func (f *Filesystem) Context() context.Context {
+	return f.ctx
+}
+
+// WithContext returns a shallow copy of the filesystem bound to the context.
+// Adapters that implement adapter.ContextAdapter cancel operations in flight,
+// for other adapters the context is checked before each operation.
+func (f *Filesystem) WithContext(ctx context.Context) *Filesystem {
+	if ctx == nil {
+		panic("nil context")
+	}
+
+	f2 := *f
+	f2.ctx = ctx
+
+	if c, ok := f.adapter.(adapter.ContextAdapter); ok {
+		f2.adapter = c.WithContext(ctx)
+	}
+
+	return &f2
+}
+
+// CreateDirContext creates a new directory using the context.
+func (f *Filesystem) CreateDirContext(ctx context.Context, path string, opts ...Option) error {
+	return f.WithContext(ctx).CreateDir(path, opts...)
+}
+
+// CopyContext will copy a file from source path to destionation path using the context.
+func (f *Filesystem) CopyContext(ctx context.Context, src string, dst string) error {
+	return f.WithContext(ctx).Copy(src, dst)
+}
+
+// DeleteContext will delete a file from source path using the context.
+func (f *Filesystem) DeleteContext(ctx context.Context, path string) error {
+	return f.WithContext(ctx).Delete(path)
+}
+
+// DeleteDirContext will delete a directory using the context.
+func (f *Filesystem) DeleteDirContext(ctx context.Context, path string) error {
+	return f.WithContext(ctx).DeleteDir(path)
+}
+
+// DeleteEmptyDirContext will delete a directory only if it's empty using the context.
+func (f *Filesystem) DeleteEmptyDirContext(ctx context.Context, path string) error {
+	return f.WithContext(ctx).DeleteEmptyDir(path)
+}
+
+// HasContext will check if a file exists using the context.
+func (f *Filesystem) HasContext(ctx context.Context, path string) (bool, error) {
+	return f.WithContext(ctx).Has(path)
+}
+
+// HasDirContext will check if a directory exists using the context.
+func (f *Filesystem) HasDirContext(ctx context.Context, path string) (bool, error) {
+	return f.WithContext(ctx).HasDir(path)
+}
+
+// ListContentsContext will list the files and directories in a directory using the context.
+func (f *Filesystem) ListContentsContext(ctx context.Context, dir string, recursive bool) ([]adapter.Entry, error) {
+	return f.WithContext(ctx).ListContents(dir, recursive)
+}
+
+// MimeTypeContext will return the file mime type using the context.
+func (f *Filesystem) MimeTypeContext(ctx context.Context, path string) (string, error) {
+	return f.WithContext(ctx).MimeType(path)
+}
+
+// PutContext will write content to a file, creating or overwriting it, using the context.
+func (f *Filesystem) PutContext(ctx context.Context, path, content string, opts ...Option) error {
+	return f.WithContext(ctx).Put(path, content, opts...)
+}
+
+// ReadContext will read file content using the context.
+func (f *Filesystem) ReadContext(ctx context.Context, path string) (string, error) {
+	return f.WithContext(ctx).Read(path)
+}
+
+// ReadAndDeleteContext will read file content and then delete the file using the context.
+func (f *Filesystem) ReadAndDeleteContext(ctx context.Context, path string) (string, error) {
+	return f.WithContext(ctx).ReadAndDelete(path)
+}
+
+// ReadStreamContext will return a reader for the file content using the context.
+func (f *Filesystem) ReadStreamContext(ctx context.Context, path string) (io.ReadCloser, error) {
+	return f.WithContext(ctx).ReadStream(path)
+}
+
+// ReadRangeContext will return a reader for part of the file content using the context.
+func (f *Filesystem) ReadRangeContext(ctx context.Context, path string, offset, length int64) (io.ReadCloser, error) {
+	return f.WithContext(ctx).ReadRange(path, offset, length)
+}
+
+// RenameContext will rename a file using the context.
+func (f *Filesystem) RenameContext(ctx context.Context, src string, dst string) error {
+	return f.WithContext(ctx).Rename(src, dst)
+}
+
+// SetVisibilityContext will change the visibility of a file using the context.
+func (f *Filesystem) SetVisibilityContext(ctx context.Context, path, visibility string) error {
+	return f.WithContext(ctx).SetVisibility(path, visibility)
+}
+
+// StatContext will return the metadata of a file using the context.
+func (f *Filesystem) StatContext(ctx context.Context, path string) (adapter.FileInfo, error) {
+	return f.WithContext(ctx).Stat(path)
+}
+
+// PostPolicyContext will return a signed HTML form for browsers to upload a file using the context.
+func (f *Filesystem) PostPolicyContext(ctx context.Context, dir string, expiry time.Duration, minSize, maxSize int64, opts ...Option) (PostPolicy, error) {
+	return f.WithContext(ctx).PostPolicy(dir, expiry, minSize, maxSize, opts...)
+}
+
+// TemporaryURLContext will return a signed URL to download a file using the context.
+func (f *Filesystem) TemporaryURLContext(ctx context.Context, path string, expiry time.Duration, opts ...Option) (string, error) {
+	return f.WithContext(ctx).TemporaryURL(path, expiry, opts...)
+}
+
+// TemporaryUploadURLContext will return a signed URL to upload a file using the context.
+func (f *Filesystem) TemporaryUploadURLContext(ctx context.Context, path string, expiry time.Duration, opts ...Option) (string, error) {
+	return f.WithContext(ctx).TemporaryUploadURL(path, expiry, opts...)
+}
+
+// UpdateContext will overwrite a existing file using the context.
+func (f *Filesystem) UpdateContext(ctx context.Context, path, content string, opts ...Option) error {
+	return f.WithContext(ctx).Update(path, content, opts...)
+}
+
+// URLContext will return a public URL for a file using the context.
+func (f *Filesystem) URLContext(ctx context.Context, path string) (string, error) {
+	return f.WithContext(ctx).URL(path)
+}
+
+// VisibilityContext will return the visibility of a file using the context.
+func (f *Filesystem) VisibilityContext(ctx context.Context, path string) (string, error) {
+	return f.WithContext(ctx).Visibility(path)
+}
+
+// WriteContext will write content to a file using the context.
+func (f *Filesystem) WriteContext(ctx context.Context, path, content string, opts ...Option) error {
+	return f.WithContext(ctx).Write(path, content, opts...)
+}
+
+// WriteStreamContext will write content from a reader to a file using the context.
+func (f *Filesystem) WriteStreamContext(ctx context.Context, path string, r io.Reader, opts ...Option) error {
+	return f.WithContext(ctx).WriteStream(path, r, opts...)
+}
+
+// WriteNewContext will write content to a new file using the context.
+func (f *Filesystem) WriteNewContext(ctx context.Context, path, content string, opts ...Option) error {
+	return f.WithContext(ctx).WriteNew(path, content, opts...)
+}
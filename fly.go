@@ -1,68 +1,663 @@
 package fly
 
-import "github.com/frozzare/go-fly/adapter"
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/frozzare/go-fly/adapter"
+)
+
+// Errors returned by the adapters, see the adapter package for details.
+var (
+	ErrNotExist          = adapter.ErrNotExist
+	ErrExist             = adapter.ErrExist
+	ErrIsDir             = adapter.ErrIsDir
+	ErrNotDir            = adapter.ErrNotDir
+	ErrPermission        = adapter.ErrPermission
+	ErrInvalidPath       = adapter.ErrInvalidPath
+	ErrInvalidVisibility = adapter.ErrInvalidVisibility
+	ErrInvalidRange      = adapter.ErrInvalidRange
+	ErrDirNotEmpty       = adapter.ErrDirNotEmpty
+	ErrReadOnly          = adapter.ErrReadOnly
+	ErrNotSupported      = adapter.ErrNotSupported
+)
+
+// Config represents the options used when writing files and creating directories.
+type Config = adapter.Config
+
+// Option represents a function that modifies the config.
+type Option = adapter.Option
+
+// Options for writing files and creating directories, see the adapter package for details.
+var (
+	WithPermissions        = adapter.WithPermissions
+	WithVisibility         = adapter.WithVisibility
+	WithContentType        = adapter.WithContentType
+	WithCacheControl       = adapter.WithCacheControl
+	WithContentDisposition = adapter.WithContentDisposition
+	WithMetadata           = adapter.WithMetadata
+	WithEncryption         = adapter.WithEncryption
+)
+
+// Visibility values.
+const (
+	VisibilityPublic  = adapter.VisibilityPublic
+	VisibilityPrivate = adapter.VisibilityPrivate
+)
+
+// Encryption represents how the storage encrypts a file at rest.
+type Encryption = adapter.Encryption
+
+// PostPolicy represents a HTML form that uploads a file straight to the storage.
+type PostPolicy = adapter.PostPolicy
+
+// PathError records an error and the operation, path and adapter that caused it.
+type PathError = adapter.PathError
 
 // Filesystem repretents a fly filesystem.
 type Filesystem struct {
-	adapter adapter.Adapter
+	adapter   adapter.Adapter
+	ctx       context.Context
+	normalize func(string) string
+	portable  bool
 }
 
 // NewFly creates a new filesystem struct.
 func NewFly(adapter adapter.Adapter) *Filesystem {
-	return &Filesystem{adapter: adapter}
+	return &Filesystem{adapter: adapter, ctx: context.Background()}
 }
 
 // CreateDir creates a new directory.
-func (f *Filesystem) CreateDir(path string, args ...interface{}) error {
-	return f.adapter.CreateDir(path)
+func (f *Filesystem) CreateDir(path string, opts ...Option) error {
+	if err := f.ctx.Err(); err != nil {
+		return err
+	}
+
+	path, err := f.path("createdir", path)
+	if err != nil {
+		return err
+	}
+
+	return f.adapter.CreateDir(path, opts...)
 }
 
 // Copy will copy a file from source path to destionation path.
+// Adapters that don't implement adapter.ServerSideCopier
+// are copied by reading and writing the file. Copying a file
+// onto itself does nothing.
 func (f *Filesystem) Copy(src string, dst string) error {
-	return f.adapter.Copy(src, dst)
+	if err := f.ctx.Err(); err != nil {
+		return err
+	}
+
+	src, err := f.path("copy", src)
+	if err != nil {
+		return err
+	}
+
+	dst, err = f.path("copy", dst)
+	if err != nil {
+		return err
+	}
+
+	// The fallbacks would truncate the file before it's read.
+	if src == dst {
+		return nil
+	}
+
+	return f.copy(src, dst)
+}
+
+// copy copies the file natively when the adapter supports it,
+// otherwise it's streamed or read and written.
+func (f *Filesystem) copy(src, dst string) error {
+	if c, ok := f.adapter.(adapter.ServerSideCopier); ok {
+		return c.Copy(src, dst)
+	}
+
+	if s, ok := f.adapter.(adapter.Streamer); ok {
+		r, err := s.ReadStream(src)
+		if err != nil {
+			return err
+		}
+
+		defer r.Close()
+
+		return s.WriteStream(dst, r)
+	}
+
+	content, err := f.adapter.Read(src)
+	if err != nil {
+		return err
+	}
+
+	return f.adapter.Write(dst, content)
 }
 
 // Delete will delete a file from source path.
 func (f *Filesystem) Delete(path string) error {
+	if err := f.ctx.Err(); err != nil {
+		return err
+	}
+
+	path, err := f.path("delete", path)
+	if err != nil {
+		return err
+	}
+
 	return f.adapter.Delete(path)
 }
 
-// DeleteDir will delete a directory.
+// DeleteDir will delete a directory and everything in it.
 func (f *Filesystem) DeleteDir(path string) error {
+	if err := f.ctx.Err(); err != nil {
+		return err
+	}
+
+	path, err := f.path("deletedir", path)
+	if err != nil {
+		return err
+	}
+
+	return f.adapter.DeleteDir(path)
+}
+
+// DeleteEmptyDir will delete a directory only if it's empty,
+// otherwise ErrDirNotEmpty is returned.
+func (f *Filesystem) DeleteEmptyDir(path string) error {
+	if err := f.ctx.Err(); err != nil {
+		return err
+	}
+
+	path, err := f.path("deleteemptydir", path)
+	if err != nil {
+		return err
+	}
+
+	if d, ok := f.adapter.(adapter.EmptyDirDeleter); ok {
+		return d.DeleteEmptyDir(path)
+	}
+
+	l, ok := f.adapter.(adapter.Lister)
+	if !ok {
+		return ErrNotSupported
+	}
+
+	entries, err := l.ListContents(path, false)
+	if err != nil {
+		return err
+	}
+
+	if len(entries) > 0 {
+		return &PathError{Op: "deleteemptydir", Path: path, Adapter: "fly", Err: ErrDirNotEmpty}
+	}
+
 	return f.adapter.DeleteDir(path)
 }
 
 // Has will check if a file exists.
 func (f *Filesystem) Has(path string) (bool, error) {
+	if err := f.ctx.Err(); err != nil {
+		return false, err
+	}
+
+	path, err := f.path("has", path)
+	if err != nil {
+		return false, err
+	}
+
 	return f.adapter.Has(path)
 }
 
 // HasDir will check if a directory exists.
 func (f *Filesystem) HasDir(path string) (bool, error) {
+	if err := f.ctx.Err(); err != nil {
+		return false, err
+	}
+
+	path, err := f.dirPath("hasdir", path)
+	if err != nil {
+		return false, err
+	}
+
 	return f.adapter.HasDir(path)
 }
 
+// ListContents will list the files and directories in a directory.
+// Sub directories are included when recursive is true.
+func (f *Filesystem) ListContents(dir string, recursive bool) ([]adapter.Entry, error) {
+	if err := f.ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	dir, err := f.dirPath("listcontents", dir)
+	if err != nil {
+		return nil, err
+	}
+
+	if l, ok := f.adapter.(adapter.Lister); ok {
+		return l.ListContents(dir, recursive)
+	}
+
+	return nil, ErrNotSupported
+}
+
 // MimeType will return the file mime type.
 func (f *Filesystem) MimeType(path string) (string, error) {
+	if err := f.ctx.Err(); err != nil {
+		return "", err
+	}
+
+	path, err := f.path("mimetype", path)
+	if err != nil {
+		return "", err
+	}
+
 	return f.adapter.MimeType(path)
 }
 
+// Put will write content to a file, creating or overwriting it.
+func (f *Filesystem) Put(path, content string, opts ...Option) error {
+	return f.Write(path, content, opts...)
+}
+
 // Read will read file content.
 func (f *Filesystem) Read(path string) (string, error) {
+	if err := f.ctx.Err(); err != nil {
+		return "", err
+	}
+
+	path, err := f.path("read", path)
+	if err != nil {
+		return "", err
+	}
+
 	return f.adapter.Read(path)
 }
 
 // ReadAndDelete will read file content and then delete the file.
 func (f *Filesystem) ReadAndDelete(path string) (string, error) {
+	if err := f.ctx.Err(); err != nil {
+		return "", err
+	}
+
+	path, err := f.path("readanddelete", path)
+	if err != nil {
+		return "", err
+	}
+
 	return f.adapter.ReadAndDelete(path)
 }
 
+// ReadStream will return a reader for the file content. Adapters that don't
+// implement adapter.Streamer read the whole range of a adapter.RangeReader
+// or else the whole file. The caller must close the reader when done.
+func (f *Filesystem) ReadStream(path string) (io.ReadCloser, error) {
+	if err := f.ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	path, err := f.path("readstream", path)
+	if err != nil {
+		return nil, err
+	}
+
+	if s, ok := f.adapter.(adapter.Streamer); ok {
+		return s.ReadStream(path)
+	}
+
+	if r, ok := f.adapter.(adapter.RangeReader); ok {
+		return r.ReadRange(path, 0, -1)
+	}
+
+	content, err := f.adapter.Read(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return ioutil.NopCloser(strings.NewReader(content)), nil
+}
+
+// ReadRange will return a reader for length bytes of the file content from the
+// offset, a negative length reads to the end of the file. Adapters that don't
+// implement adapter.RangeReader read the file from the start and skip the offset.
+// The caller must close the reader when done.
+func (f *Filesystem) ReadRange(path string, offset, length int64) (io.ReadCloser, error) {
+	if err := f.ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	path, err := f.path("readrange", path)
+	if err != nil {
+		return nil, err
+	}
+
+	if offset < 0 {
+		return nil, &adapter.PathError{Op: "readrange", Path: path, Adapter: "fly", Err: ErrInvalidRange}
+	}
+
+	if r, ok := f.adapter.(adapter.RangeReader); ok {
+		return r.ReadRange(path, offset, length)
+	}
+
+	rc, err := f.ReadStream(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := io.CopyN(ioutil.Discard, rc, offset); err != nil && err != io.EOF {
+		rc.Close()
+		return nil, err
+	}
+
+	if length < 0 {
+		return rc, nil
+	}
+
+	return &struct {
+		io.Reader
+		io.Closer
+	}{io.LimitReader(rc, length), rc}, nil
+}
+
 // Rename will rename a file.
+// Adapters that don't implement adapter.Renamer
+// are renamed by copying and deleting the file.
+// Renaming a file to itself does nothing.
 func (f *Filesystem) Rename(src string, dst string) error {
-	return f.adapter.Rename(src, dst)
+	if err := f.ctx.Err(); err != nil {
+		return err
+	}
+
+	src, err := f.path("rename", src)
+	if err != nil {
+		return err
+	}
+
+	dst, err = f.path("rename", dst)
+	if err != nil {
+		return err
+	}
+
+	// The fallback would delete the file after copying it onto itself.
+	if src == dst {
+		return nil
+	}
+
+	if r, ok := f.adapter.(adapter.Renamer); ok {
+		return r.Rename(src, dst)
+	}
+
+	if err := f.copy(src, dst); err != nil {
+		return err
+	}
+
+	return f.adapter.Delete(src)
+}
+
+// SetVisibility will change the visibility of a file to VisibilityPublic or VisibilityPrivate.
+// ErrNotSupported is returned when the adapter doesn't implement adapter.Visibility.
+func (f *Filesystem) SetVisibility(path, visibility string) error {
+	if err := f.ctx.Err(); err != nil {
+		return err
+	}
+
+	path, err := f.path("setvisibility", path)
+	if err != nil {
+		return err
+	}
+
+	if v, ok := f.adapter.(adapter.Visibility); ok {
+		return v.SetVisibility(path, visibility)
+	}
+
+	return ErrNotSupported
+}
+
+// Stat will return the size, last modified time, mime type,
+// ETag and visibility of a file.
+func (f *Filesystem) Stat(path string) (adapter.FileInfo, error) {
+	if err := f.ctx.Err(); err != nil {
+		return adapter.FileInfo{}, err
+	}
+
+	path, err := f.path("stat", path)
+	if err != nil {
+		return adapter.FileInfo{}, err
+	}
+
+	if s, ok := f.adapter.(adapter.Stater); ok {
+		return s.Stat(path)
+	}
+
+	content, err := f.adapter.Read(path)
+	if err != nil {
+		return adapter.FileInfo{}, err
+	}
+
+	typ, err := f.adapter.MimeType(path)
+	if err != nil {
+		return adapter.FileInfo{}, err
+	}
+
+	sum := md5.Sum([]byte(content))
+
+	return adapter.FileInfo{
+		Path:     path,
+		Size:     int64(len(content)),
+		MimeType: typ,
+		ETag:     hex.EncodeToString(sum[:]),
+	}, nil
+}
+
+// Update will overwrite a existing file, ErrNotExist is returned when the file is missing.
+// Adapters that implement adapter.ConditionalWriter do the check as part of the write.
+func (f *Filesystem) Update(path, content string, opts ...Option) error {
+	if err := f.ctx.Err(); err != nil {
+		return err
+	}
+
+	path, err := f.path("update", path)
+	if err != nil {
+		return err
+	}
+
+	if w, ok := f.adapter.(adapter.ConditionalWriter); ok {
+		return w.Update(path, content, opts...)
+	}
+
+	has, err := f.adapter.Has(path)
+	if err != nil {
+		return err
+	}
+
+	if !has {
+		return &PathError{Op: "update", Path: path, Adapter: "fly", Err: ErrNotExist}
+	}
+
+	return f.adapter.Write(path, content, opts...)
+}
+
+// PostPolicy will return a signed HTML form for browsers to upload a file
+// to the directory with a size between the min and max bytes before the
+// expiry, a max size of 0 allows any size. The options are required form
+// values, e.g. the content type. ErrNotSupported is returned when the
+// adapter doesn't implement adapter.PostPolicyGenerator.
+func (f *Filesystem) PostPolicy(dir string, expiry time.Duration, minSize, maxSize int64, opts ...Option) (PostPolicy, error) {
+	if err := f.ctx.Err(); err != nil {
+		return PostPolicy{}, err
+	}
+
+	dir, err := f.dirPath("postpolicy", dir)
+	if err != nil {
+		return PostPolicy{}, err
+	}
+
+	if g, ok := f.adapter.(adapter.PostPolicyGenerator); ok {
+		return g.PostPolicy(dir, expiry, minSize, maxSize, opts...)
+	}
+
+	return PostPolicy{}, ErrNotSupported
+}
+
+// TemporaryURL will return a signed URL to download a file until the expiry,
+// without credentials. WithContentType and WithContentDisposition override
+// the headers of the response. ErrNotSupported is returned when the adapter
+// doesn't implement adapter.TemporaryURLGenerator.
+func (f *Filesystem) TemporaryURL(path string, expiry time.Duration, opts ...Option) (string, error) {
+	if err := f.ctx.Err(); err != nil {
+		return "", err
+	}
+
+	path, err := f.path("temporaryurl", path)
+	if err != nil {
+		return "", err
+	}
+
+	if g, ok := f.adapter.(adapter.TemporaryURLGenerator); ok {
+		return g.TemporaryURL(path, expiry, opts...)
+	}
+
+	return "", ErrNotSupported
+}
+
+// TemporaryUploadURL will return a signed URL to upload a file with a PUT
+// request until the expiry, without credentials. The options are part of the
+// signature, so the request must send the matching headers. ErrNotSupported is
+// returned when the adapter doesn't implement adapter.TemporaryURLGenerator.
+func (f *Filesystem) TemporaryUploadURL(path string, expiry time.Duration, opts ...Option) (string, error) {
+	if err := f.ctx.Err(); err != nil {
+		return "", err
+	}
+
+	path, err := f.path("temporaryuploadurl", path)
+	if err != nil {
+		return "", err
+	}
+
+	if g, ok := f.adapter.(adapter.TemporaryURLGenerator); ok {
+		return g.TemporaryUploadURL(path, expiry, opts...)
+	}
+
+	return "", ErrNotSupported
+}
+
+// URL will return a public URL for a file.
+// ErrNotSupported is returned when the adapter doesn't implement adapter.URLGenerator.
+func (f *Filesystem) URL(path string) (string, error) {
+	if err := f.ctx.Err(); err != nil {
+		return "", err
+	}
+
+	path, err := f.path("url", path)
+	if err != nil {
+		return "", err
+	}
+
+	if g, ok := f.adapter.(adapter.URLGenerator); ok {
+		return g.URL(path)
+	}
+
+	return "", ErrNotSupported
+}
+
+// Visibility will return the visibility of a file, VisibilityPublic or VisibilityPrivate.
+// Adapters that don't implement adapter.Visibility fall back on the visibility from
+// adapter.Stater, ErrNotSupported is returned when neither reports it.
+func (f *Filesystem) Visibility(path string) (string, error) {
+	if err := f.ctx.Err(); err != nil {
+		return "", err
+	}
+
+	path, err := f.path("visibility", path)
+	if err != nil {
+		return "", err
+	}
+
+	if v, ok := f.adapter.(adapter.Visibility); ok {
+		return v.Visibility(path)
+	}
+
+	if s, ok := f.adapter.(adapter.Stater); ok {
+		info, err := s.Stat(path)
+		if err != nil {
+			return "", err
+		}
+
+		if info.Visibility != "" {
+			return info.Visibility, nil
+		}
+	}
+
+	return "", ErrNotSupported
 }
 
-// Write will write content to a file.
-func (f *Filesystem) Write(path, content string, args ...interface{}) error {
-	return f.adapter.Write(path, content)
+// Write will write content to a file, creating or overwriting it.
+func (f *Filesystem) Write(path, content string, opts ...Option) error {
+	if err := f.ctx.Err(); err != nil {
+		return err
+	}
+
+	path, err := f.path("write", path)
+	if err != nil {
+		return err
+	}
+
+	return f.adapter.Write(path, content, opts...)
+}
+
+// WriteStream will write content from a reader to a file.
+func (f *Filesystem) WriteStream(path string, r io.Reader, opts ...Option) error {
+	if err := f.ctx.Err(); err != nil {
+		return err
+	}
+
+	path, err := f.path("writestream", path)
+	if err != nil {
+		return err
+	}
+
+	if s, ok := f.adapter.(adapter.Streamer); ok {
+		return s.WriteStream(path, r, opts...)
+	}
+
+	buf, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	return f.adapter.Write(path, string(buf), opts...)
+}
+
+// WriteNew will write content to a new file, ErrExist is returned when the file exists.
+// Adapters that implement adapter.ConditionalWriter do the check as part of the write.
+func (f *Filesystem) WriteNew(path, content string, opts ...Option) error {
+	if err := f.ctx.Err(); err != nil {
+		return err
+	}
+
+	path, err := f.path("writenew", path)
+	if err != nil {
+		return err
+	}
+
+	if w, ok := f.adapter.(adapter.ConditionalWriter); ok {
+		return w.WriteNew(path, content, opts...)
+	}
+
+	has, err := f.adapter.Has(path)
+	if err != nil {
+		return err
+	}
+
+	if has {
+		return &PathError{Op: "writenew", Path: path, Adapter: "fly", Err: ErrExist}
+	}
+
+	return f.adapter.Write(path, content, opts...)
 }
@@ -1,10 +1,19 @@
 package fly
 
 import (
+	"context"
+	"errors"
+	iofs "io/fs"
+	"io/ioutil"
+	"os"
 	"strings"
 	"testing"
+	"testing/fstest"
+	"text/template"
+	"time"
 
 	"github.com/frozzare/go-assert"
+	"github.com/frozzare/go-fly/adapter"
 	"github.com/frozzare/go-fly/adapter/flylocal"
 )
 
@@ -58,3 +67,421 @@ func TestFileMimeType(t *testing.T) {
 	typ, err := fs.MimeType("test/hello.txt")
 	assert.Equal(t, "text/plain", typ)
 }
+
+// stringAdapter hides every optional interface of the wrapped adapter.
+type stringAdapter struct {
+	adapter.Adapter
+}
+
+func TestFileStream(t *testing.T) {
+	for _, fs := range []*Filesystem{
+		NewFly(flylocal.NewAdapter("/tmp/fly")),
+		NewFly(stringAdapter{flylocal.NewAdapter("/tmp/fly")}),
+	} {
+		err := fs.WriteStream("test/stream.txt", strings.NewReader("Hello, stream!"))
+		assert.Nil(t, err)
+
+		r, err := fs.ReadStream("test/stream.txt")
+		assert.Nil(t, err)
+
+		buf, err := ioutil.ReadAll(r)
+		assert.Nil(t, err)
+		assert.Nil(t, r.Close())
+		assert.Equal(t, "Hello, stream!", string(buf))
+
+		err = fs.Delete("test/stream.txt")
+		assert.Nil(t, err)
+	}
+}
+
+func TestListContents(t *testing.T) {
+	fs := NewFly(flylocal.NewAdapter("/tmp/fly"))
+
+	err := fs.Write("test/list/hello.txt", "Hello, world!")
+	assert.Nil(t, err)
+
+	entries, err := fs.ListContents("test/list", false)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(entries))
+	assert.Equal(t, "test/list/hello.txt", entries[0].Path)
+
+	_, err = NewFly(stringAdapter{fs.adapter}).ListContents("test/list", false)
+	assert.Equal(t, ErrNotSupported, err)
+}
+
+func TestStat(t *testing.T) {
+	for _, fs := range []*Filesystem{
+		NewFly(flylocal.NewAdapter("/tmp/fly")),
+		NewFly(stringAdapter{flylocal.NewAdapter("/tmp/fly")}),
+	} {
+		err := fs.Write("test/hello.txt", "Hello, world!")
+		assert.Nil(t, err)
+
+		info, err := fs.Stat("test/hello.txt")
+		assert.Nil(t, err)
+		assert.Equal(t, int64(13), info.Size)
+		assert.Equal(t, "text/plain", info.MimeType)
+		assert.NotEmpty(t, info.ETag)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	fs := NewFly(flylocal.NewAdapter("/tmp/fly"))
+
+	_, err := fs.Read("test/missing.txt")
+	assert.True(t, errors.Is(err, ErrNotExist))
+	assert.True(t, errors.Is(err, os.ErrNotExist))
+
+	var pathErr *PathError
+	assert.True(t, errors.As(err, &pathErr))
+	assert.Equal(t, "test/missing.txt", pathErr.Path)
+}
+
+func TestWriteOptions(t *testing.T) {
+	fs := NewFly(flylocal.NewAdapter("/tmp/fly"))
+
+	err := fs.Write("test/private.txt", "secret", WithVisibility(VisibilityPrivate))
+	assert.Nil(t, err)
+
+	info, err := fs.Stat("test/private.txt")
+	assert.Nil(t, err)
+	assert.Equal(t, VisibilityPrivate, info.Visibility)
+
+	assert.Nil(t, fs.Delete("test/private.txt"))
+}
+
+func TestContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	for _, fs := range []*Filesystem{
+		NewFly(flylocal.NewAdapter("/tmp/fly")),
+		NewFly(stringAdapter{flylocal.NewAdapter("/tmp/fly")}),
+	} {
+		_, err := fs.ReadContext(ctx, "test/hello.txt")
+		assert.True(t, errors.Is(err, context.Canceled))
+
+		err = fs.WithContext(ctx).Write("test/hello.txt", "Hello, world!")
+		assert.True(t, errors.Is(err, context.Canceled))
+
+		assert.Equal(t, context.Background(), fs.Context())
+		assert.Equal(t, ctx, fs.WithContext(ctx).Context())
+	}
+}
+
+func TestCleanPath(t *testing.T) {
+	tests := []struct {
+		in      string
+		out     string
+		invalid bool
+	}{
+		{"", "", false},
+		{"/", "", false},
+		{"./", "", false},
+		{"a", "a", false},
+		{"/a/b/", "a/b", false},
+		{"//a//b", "a/b", false},
+		{"./a/./b", "a/b", false},
+		{"a/b/../c", "a/c", false},
+		{"a\\b\\c.txt", "a/b/c.txt", false},
+		{"héllo/wörld.txt", "héllo/wörld.txt", false},
+		{"..", "", true},
+		{"/../a", "", true},
+		{"a/../../b", "", true},
+		{"a\\..\\..\\b", "", true},
+		{"a\x00b", "", true},
+	}
+
+	for _, test := range tests {
+		p, err := CleanPath(test.in)
+		assert.Equal(t, test.out, p, test.in)
+		assert.Equal(t, test.invalid, errors.Is(err, ErrInvalidPath), test.in)
+	}
+}
+
+func TestPathNormalization(t *testing.T) {
+	fs := NewFly(flylocal.NewAdapter("/tmp/fly"))
+
+	_, err := fs.Read("../../etc/passwd")
+	assert.True(t, errors.Is(err, ErrInvalidPath))
+
+	err = fs.DeleteDir("/")
+	assert.True(t, errors.Is(err, ErrInvalidPath))
+
+	err = fs.Write("/test//./normalized.txt", "Hello, world!")
+	assert.Nil(t, err)
+
+	has, err := fs.Has("test\\normalized.txt")
+	assert.True(t, has)
+	assert.Nil(t, err)
+
+	has, err = fs.WithPathNormalizer(strings.ToLower).Has("TEST/Normalized.txt")
+	assert.True(t, has)
+	assert.Nil(t, err)
+
+	err = fs.WithNFC().Write("test/cafe\u0301.txt", "Hello, world!")
+	assert.Nil(t, err)
+
+	has, err = fs.Has("test/caf\u00e9.txt")
+	assert.True(t, has)
+	assert.Nil(t, err)
+
+	has, err = fs.Has("test/cafe\u0301.txt")
+	assert.False(t, has)
+	assert.Nil(t, err)
+
+	assert.Nil(t, fs.Delete("test/caf\u00e9.txt"))
+
+	assert.Nil(t, fs.Delete("test/normalized.txt"))
+}
+
+func TestPortableNames(t *testing.T) {
+	tests := []struct {
+		in       string
+		portable bool
+	}{
+		{"", true},
+		{"a/b/c.txt", true},
+		{"héllo/wörld.txt", true},
+		{"a b+c#d.txt", true},
+		{".hidden", true},
+		{"a:b.txt", false},
+		{"a/b?.txt", false},
+		{"a\tb.txt", false},
+		{"a\xffb.txt", false},
+		{"dir./a.txt", false},
+		{"a.txt ", false},
+		{"con", false},
+		{"a/LPT1.txt", false},
+		{"console.txt", true},
+		{strings.Repeat("a", 256), false},
+		{strings.Repeat("a/", 512) + "a", false},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.portable, PortablePath(test.in), test.in)
+	}
+
+	fs := NewFly(flylocal.NewAdapter("/tmp/fly")).WithPortableNames()
+
+	err := fs.Write("test/a:b.txt", "Hello, world!")
+	assert.True(t, errors.Is(err, ErrInvalidPath))
+
+	err = fs.Write("test/portable.txt", "Hello, world!")
+	assert.Nil(t, err)
+
+	assert.Nil(t, fs.Delete("test/portable.txt"))
+}
+
+func TestCopyOntoItself(t *testing.T) {
+	for _, fs := range []*Filesystem{
+		NewFly(flylocal.NewAdapter("/tmp/fly")),
+		NewFly(stringAdapter{flylocal.NewAdapter("/tmp/fly")}),
+	} {
+		assert.Nil(t, fs.Write("test/same.txt", "Hello, world!"))
+		assert.Nil(t, fs.Copy("test/same.txt", "./test/same.txt"))
+		assert.Nil(t, fs.Rename("test/same.txt", "/test/same.txt"))
+
+		content, err := fs.Read("test/same.txt")
+		assert.Nil(t, err)
+		assert.Equal(t, "Hello, world!", content)
+
+		assert.Nil(t, fs.Delete("test/same.txt"))
+	}
+}
+
+func TestDeleteDir(t *testing.T) {
+	for _, fs := range []*Filesystem{
+		NewFly(flylocal.NewAdapter("/tmp/fly")),
+		NewFly(stringAdapter{flylocal.NewAdapter("/tmp/fly")}),
+	} {
+		assert.Nil(t, fs.Write("test/tree/sub/hello.txt", "Hello, world!"))
+
+		err := fs.DeleteEmptyDir("test/tree")
+		if _, ok := fs.adapter.(stringAdapter); ok {
+			assert.Equal(t, ErrNotSupported, err)
+		} else {
+			assert.True(t, errors.Is(err, ErrDirNotEmpty))
+		}
+
+		assert.Nil(t, fs.DeleteDir("test/tree"))
+
+		has, err := fs.HasDir("test/tree")
+		assert.False(t, has)
+		assert.Nil(t, err)
+	}
+}
+
+func TestConditionalWrites(t *testing.T) {
+	for _, fs := range []*Filesystem{
+		NewFly(flylocal.NewAdapter("/tmp/fly")),
+		NewFly(stringAdapter{flylocal.NewAdapter("/tmp/fly")}),
+	} {
+		fs.Delete("test/new.txt")
+
+		err := fs.Update("test/new.txt", "updated")
+		assert.True(t, errors.Is(err, ErrNotExist))
+
+		err = fs.WriteNew("test/new.txt", "new")
+		assert.Nil(t, err)
+
+		err = fs.WriteNew("test/new.txt", "new")
+		assert.True(t, errors.Is(err, ErrExist))
+
+		err = fs.Update("test/new.txt", "updated")
+		assert.Nil(t, err)
+
+		err = fs.Put("test/new.txt", "put")
+		assert.Nil(t, err)
+
+		content, err := fs.Read("test/new.txt")
+		assert.Nil(t, err)
+		assert.Equal(t, "put", content)
+	}
+}
+
+func TestCopyAndRename(t *testing.T) {
+	for _, fs := range []*Filesystem{
+		NewFly(flylocal.NewAdapter("/tmp/fly")),
+		NewFly(stringAdapter{flylocal.NewAdapter("/tmp/fly")}),
+	} {
+		err := fs.Write("test/hello.txt", "Hello, world!")
+		assert.Nil(t, err)
+
+		err = fs.Copy("test/hello.txt", "test/copy/hello.txt")
+		assert.Nil(t, err)
+
+		err = fs.Rename("test/copy/hello.txt", "test/rename/hello.txt")
+		assert.Nil(t, err)
+
+		has, err := fs.Has("test/copy/hello.txt")
+		assert.False(t, has)
+		assert.Nil(t, err)
+
+		content, err := fs.Read("test/rename/hello.txt")
+		assert.Nil(t, err)
+		assert.Equal(t, "Hello, world!", content)
+
+		err = fs.Rename("test/missing.txt", "test/rename/missing.txt")
+		assert.True(t, errors.Is(err, ErrNotExist))
+
+		assert.Nil(t, fs.DeleteDir("test/copy"))
+		assert.Nil(t, fs.DeleteDir("test/rename"))
+	}
+}
+
+func TestReadRange(t *testing.T) {
+	fs := NewFly(stringAdapter{flylocal.NewAdapter("/tmp/fly")})
+
+	err := fs.Write("test/hello.txt", "Hello, world!")
+	assert.Nil(t, err)
+
+	for _, tt := range []struct {
+		offset, length int64
+		expected       string
+	}{
+		{7, 5, "world"},
+		{7, -1, "world!"},
+		{100, 5, ""},
+	} {
+		r, err := fs.ReadRange("test/hello.txt", tt.offset, tt.length)
+		assert.Nil(t, err)
+
+		content, err := ioutil.ReadAll(r)
+		assert.Nil(t, err)
+		assert.Nil(t, r.Close())
+		assert.Equal(t, tt.expected, string(content))
+	}
+
+	_, err = fs.ReadRange("test/hello.txt", -1, 5)
+	assert.True(t, errors.Is(err, ErrInvalidRange))
+
+	_, err = fs.ReadRange("test/missing.txt", 0, 5)
+	assert.True(t, errors.Is(err, ErrNotExist))
+}
+
+func TestVisibility(t *testing.T) {
+	fs := NewFly(flylocal.NewAdapter("/tmp/fly"))
+
+	err := fs.Write("test/hello.txt", "Hello, world!")
+	assert.Nil(t, err)
+
+	err = fs.SetVisibility("test/hello.txt", VisibilityPrivate)
+	assert.Nil(t, err)
+
+	visibility, err := fs.Visibility("test/hello.txt")
+	assert.Nil(t, err)
+	assert.Equal(t, VisibilityPrivate, visibility)
+
+	err = fs.SetVisibility("test/hello.txt", "everyone")
+	assert.True(t, errors.Is(err, ErrInvalidVisibility))
+
+	fs = NewFly(stringAdapter{fs.adapter})
+
+	err = fs.SetVisibility("test/hello.txt", VisibilityPublic)
+	assert.Equal(t, ErrNotSupported, err)
+
+	_, err = fs.Visibility("test/hello.txt")
+	assert.Equal(t, ErrNotSupported, err)
+
+	_, err = fs.URL("test/hello.txt")
+	assert.Equal(t, ErrNotSupported, err)
+
+	_, err = fs.TemporaryURL("test/hello.txt", time.Hour)
+	assert.Equal(t, ErrNotSupported, err)
+
+	_, err = fs.TemporaryUploadURL("test/hello.txt", time.Hour)
+	assert.Equal(t, ErrNotSupported, err)
+
+	_, err = fs.PostPolicy("test", time.Hour, 0, 1024)
+	assert.Equal(t, ErrNotSupported, err)
+}
+
+func TestCapabilities(t *testing.T) {
+	c := NewFly(flylocal.NewAdapter("/tmp/fly")).Capabilities()
+	assert.True(t, c.Lister)
+	assert.True(t, c.Renamer)
+	assert.True(t, c.ServerSideCopier)
+	assert.True(t, c.Visibility)
+	assert.False(t, c.URLGenerator)
+
+	assert.Equal(t, Capabilities{}, NewFly(stringAdapter{flylocal.NewAdapter("/tmp/fly")}).Capabilities())
+
+	c = NewFly(emulatedAdapter{flylocal.NewAdapter("/tmp/fly")}).Capabilities()
+	assert.False(t, c.Renamer)
+	assert.True(t, c.ServerSideCopier)
+}
+
+type emulatedAdapter struct {
+	*flylocal.Adapter
+}
+
+func (emulatedAdapter) Emulated() []string {
+	return []string{"Renamer"}
+}
+
+func TestFS(t *testing.T) {
+	os.RemoveAll("/tmp/fly-iofs")
+	fs := NewFly(flylocal.NewAdapter("/tmp/fly-iofs"))
+
+	assert.Nil(t, fs.Write("index.html", "<h1>{{ .Title }}</h1>"))
+	assert.Nil(t, fs.Write("static/app.js", "console.log('fly')"))
+	assert.Nil(t, fs.Write("static/css/app.css", "body {}"))
+	assert.Nil(t, fs.Write("empty.txt", ""))
+
+	err := fstest.TestFS(fs.FS(), "index.html", "static/app.js", "static/css/app.css", "empty.txt")
+	assert.Nil(t, err)
+
+	tmpl, err := template.ParseFS(fs.FS(), "*.html")
+	assert.Nil(t, err)
+
+	var buf strings.Builder
+	assert.Nil(t, tmpl.Execute(&buf, map[string]string{"Title": "Fly"}))
+	assert.Equal(t, "<h1>Fly</h1>", buf.String())
+
+	_, err = fs.FS().Open("../etc/passwd")
+	assert.True(t, errors.Is(err, iofs.ErrInvalid))
+
+	_, err = fs.FS().Open("missing.txt")
+	assert.True(t, errors.Is(err, iofs.ErrNotExist))
+}
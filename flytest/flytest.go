@@ -0,0 +1,769 @@
+// Package flytest provides a conformance test suite for adapters.
+//
+// Adapter authors can prove their adapter is compatible with the
+// adapters in this repository by running the suite from a test:
+//
+//	func TestSuite(t *testing.T) {
+//		flytest.RunAdapterSuite(t, func() adapter.Adapter {
+//			return myadapter.NewAdapter()
+//		})
+//	}
+package flytest
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/frozzare/go-fly/adapter"
+)
+
+// RunAdapterSuite runs the conformance tests against the adapters created by newAdapter.
+// Every test gets a new adapter, which must start out empty.
+//
+// The optional interfaces in the adapter package are tested when the adapter
+// implements them, URL and POST policy generators may return
+// adapter.ErrNotSupported. Adapters that return adapter.ErrReadOnly from
+// Write are only checked to reject every write and to report missing files.
+func RunAdapterSuite(t *testing.T, newAdapter func() adapter.Adapter) {
+	t.Helper()
+
+	probe := newAdapter()
+	if err := probe.Write("flytest-probe.txt", "probe"); errors.Is(err, adapter.ErrReadOnly) {
+		t.Run("ReadOnly", func(t *testing.T) { testReadOnly(t, newAdapter()) })
+		return
+	}
+
+	probe.Delete("flytest-probe.txt")
+
+	tests := []struct {
+		name string
+		fn   func(*testing.T, adapter.Adapter)
+	}{
+		{"WriteRead", testWriteRead},
+		{"Overwrite", testOverwrite},
+		{"EmptyFile", testEmptyFile},
+		{"NestedPaths", testNestedPaths},
+		{"UnicodeNames", testUnicodeNames},
+		{"Missing", testMissing},
+		{"ReadAndDelete", testReadAndDelete},
+		{"Delete", testDelete},
+		{"Directories", testDirectories},
+		{"DeleteDir", testDeleteDir},
+		{"DeleteRoot", testDeleteRoot},
+		{"MimeType", testMimeType},
+		{"ConditionalWriter", testConditionalWriter},
+		{"EmptyDirDeleter", testEmptyDirDeleter},
+		{"Lister", testLister},
+		{"PostPolicyGenerator", testPostPolicyGenerator},
+		{"RangeReader", testRangeReader},
+		{"Renamer", testRenamer},
+		{"ServerSideCopier", testServerSideCopier},
+		{"Stater", testStater},
+		{"Streamer", testStreamer},
+		{"TemporaryURLGenerator", testTemporaryURLGenerator},
+		{"URLGenerator", testURLGenerator},
+		{"Visibility", testVisibility},
+	}
+
+	for _, tt := range tests {
+		fn := tt.fn
+		t.Run(tt.name, func(t *testing.T) { fn(t, newAdapter()) })
+	}
+}
+
+func testWriteRead(t *testing.T, a adapter.Adapter) {
+	write(t, a, "hello.txt", "Hello, world!")
+	expectContent(t, a, "hello.txt", "Hello, world!")
+	expectHas(t, a, "hello.txt", true)
+	expectHasDir(t, a, "hello.txt", false)
+}
+
+func testOverwrite(t *testing.T, a adapter.Adapter) {
+	write(t, a, "hello.txt", "Hello, world!")
+	write(t, a, "hello.txt", "Bye")
+	expectContent(t, a, "hello.txt", "Bye")
+}
+
+func testEmptyFile(t *testing.T, a adapter.Adapter) {
+	write(t, a, "empty.txt", "")
+	expectContent(t, a, "empty.txt", "")
+	expectHas(t, a, "empty.txt", true)
+}
+
+func testNestedPaths(t *testing.T, a adapter.Adapter) {
+	write(t, a, "a/b/c/hello.txt", "nested")
+	expectContent(t, a, "a/b/c/hello.txt", "nested")
+	expectHas(t, a, "a/b/c", false)
+	expectHas(t, a, "a/b/c/hello.txt/nested", false)
+
+	// Parent directories are implied by the file.
+	expectHasDir(t, a, "a", true)
+	expectHasDir(t, a, "a/b/c", true)
+	expectHasDir(t, a, "a/b/c/hello.txt", false)
+
+	write(t, a, "a/b/hello.txt", "parent")
+	expectContent(t, a, "a/b/hello.txt", "parent")
+	expectContent(t, a, "a/b/c/hello.txt", "nested")
+}
+
+func testUnicodeNames(t *testing.T, a adapter.Adapter) {
+	names := []string{
+		"unicode/héllo wörld.txt",
+		"unicode/日本語/ファイル.txt",
+		"unicode/emoji 🪰.txt",
+	}
+
+	for _, name := range names {
+		write(t, a, name, name)
+	}
+
+	for _, name := range names {
+		expectContent(t, a, name, name)
+		expectHas(t, a, name, true)
+	}
+}
+
+func testMissing(t *testing.T, a adapter.Adapter) {
+	expectHas(t, a, "missing.txt", false)
+	expectHasDir(t, a, "missing", false)
+
+	_, err := a.Read("missing.txt")
+	expectError(t, "Read", err, adapter.ErrNotExist)
+
+	_, err = a.ReadAndDelete("missing.txt")
+	expectError(t, "ReadAndDelete", err, adapter.ErrNotExist)
+
+	err = a.DeleteDir("missing")
+	expectError(t, "DeleteDir", err, adapter.ErrNotExist)
+}
+
+func testReadAndDelete(t *testing.T, a adapter.Adapter) {
+	write(t, a, "hello.txt", "Hello, world!")
+
+	content, err := a.ReadAndDelete("hello.txt")
+	if err != nil {
+		t.Fatalf("ReadAndDelete: unexpected error: %v", err)
+	}
+
+	if content != "Hello, world!" {
+		t.Errorf("ReadAndDelete: expected %q, got %q", "Hello, world!", content)
+	}
+
+	expectHas(t, a, "hello.txt", false)
+}
+
+// testDelete allows deleting a missing file to succeed, since object
+// stores like AWS S3 can't tell without a extra request.
+func testDelete(t *testing.T, a adapter.Adapter) {
+	write(t, a, "dir/hello.txt", "Hello, world!")
+	write(t, a, "dir/other.txt", "other")
+
+	if err := a.Delete("dir/hello.txt"); err != nil {
+		t.Fatalf("Delete: unexpected error: %v", err)
+	}
+
+	expectHas(t, a, "dir/hello.txt", false)
+	expectContent(t, a, "dir/other.txt", "other")
+
+	if err := a.Delete("dir/hello.txt"); err != nil && !errors.Is(err, adapter.ErrNotExist) {
+		t.Errorf("Delete of a missing file: expected nil or %v, got %v", adapter.ErrNotExist, err)
+	}
+}
+
+func testDirectories(t *testing.T, a adapter.Adapter) {
+	createDir(t, a, "dir/sub")
+	createDir(t, a, "dir/sub")
+
+	expectHasDir(t, a, "dir/sub", true)
+	expectHasDir(t, a, "dir/sub/", true)
+	expectHas(t, a, "dir/sub", false)
+
+	write(t, a, "dir/sub/hello.txt", "Hello, world!")
+	expectHasDir(t, a, "dir/sub/hello.txt", false)
+}
+
+func testDeleteDir(t *testing.T, a adapter.Adapter) {
+	createDir(t, a, "dir")
+	write(t, a, "dir/hello.txt", "Hello, world!")
+	write(t, a, "dir/sub/deep/hello.txt", "Hello, world!")
+	write(t, a, "dir-sibling/hello.txt", "sibling")
+
+	if err := a.DeleteDir("dir"); err != nil {
+		t.Fatalf("DeleteDir: unexpected error: %v", err)
+	}
+
+	expectHasDir(t, a, "dir", false)
+	expectHasDir(t, a, "dir/sub", false)
+	expectHas(t, a, "dir/hello.txt", false)
+	expectHas(t, a, "dir/sub/deep/hello.txt", false)
+	expectContent(t, a, "dir-sibling/hello.txt", "sibling")
+
+	if err := a.DeleteDir("dir-sibling/hello.txt"); err == nil {
+		t.Errorf("DeleteDir of a file: expected an error")
+	}
+
+	expectContent(t, a, "dir-sibling/hello.txt", "sibling")
+}
+
+// testDeleteRoot checks that the root can't be deleted as a directory,
+// which would delete every file of the adapter.
+func testDeleteRoot(t *testing.T, a adapter.Adapter) {
+	write(t, a, "hello.txt", "Hello, world!")
+	write(t, a, "dir/hello.txt", "Hello, world!")
+
+	ed, _ := a.(adapter.EmptyDirDeleter)
+
+	for _, p := range []string{"", "/"} {
+		if err := a.DeleteDir(p); err == nil {
+			t.Errorf("DeleteDir %q: expected an error", p)
+		}
+
+		if ed == nil {
+			continue
+		}
+
+		if err := ed.DeleteEmptyDir(p); err == nil {
+			t.Errorf("DeleteEmptyDir %q: expected an error", p)
+		}
+	}
+
+	expectContent(t, a, "hello.txt", "Hello, world!")
+	expectContent(t, a, "dir/hello.txt", "Hello, world!")
+}
+
+func testMimeType(t *testing.T, a adapter.Adapter) {
+	write(t, a, "hello.txt", "Hello, world!")
+
+	// Parameters like the charset are allowed.
+	typ, err := a.MimeType("hello.txt")
+	if err != nil {
+		t.Fatalf("MimeType: unexpected error: %v", err)
+	}
+
+	if !strings.HasPrefix(typ, "text/plain") {
+		t.Errorf("MimeType: expected text/plain, got %q", typ)
+	}
+}
+
+func testConditionalWriter(t *testing.T, a adapter.Adapter) {
+	cw, ok := a.(adapter.ConditionalWriter)
+	if !ok {
+		t.Skip("adapter doesn't implement adapter.ConditionalWriter")
+	}
+
+	err := cw.Update("hello.txt", "updated")
+	expectError(t, "Update of a missing file", err, adapter.ErrNotExist)
+	expectHas(t, a, "hello.txt", false)
+
+	if err := cw.WriteNew("dir/hello.txt", "new"); err != nil {
+		t.Fatalf("WriteNew: unexpected error: %v", err)
+	}
+
+	err = cw.WriteNew("dir/hello.txt", "again")
+	expectError(t, "WriteNew of a existing file", err, adapter.ErrExist)
+	expectContent(t, a, "dir/hello.txt", "new")
+
+	if err := cw.Update("dir/hello.txt", "updated"); err != nil {
+		t.Fatalf("Update: unexpected error: %v", err)
+	}
+
+	expectContent(t, a, "dir/hello.txt", "updated")
+}
+
+func testEmptyDirDeleter(t *testing.T, a adapter.Adapter) {
+	ed, ok := a.(adapter.EmptyDirDeleter)
+	if !ok {
+		t.Skip("adapter doesn't implement adapter.EmptyDirDeleter")
+	}
+
+	createDir(t, a, "empty")
+	createDir(t, a, "full")
+	write(t, a, "full/hello.txt", "Hello, world!")
+
+	if err := ed.DeleteEmptyDir("empty"); err != nil {
+		t.Fatalf("DeleteEmptyDir: unexpected error: %v", err)
+	}
+
+	expectHasDir(t, a, "empty", false)
+
+	err := ed.DeleteEmptyDir("full")
+	expectError(t, "DeleteEmptyDir of a directory with files", err, adapter.ErrDirNotEmpty)
+	expectContent(t, a, "full/hello.txt", "Hello, world!")
+
+	err = ed.DeleteEmptyDir("missing")
+	expectError(t, "DeleteEmptyDir of a missing directory", err, adapter.ErrNotExist)
+}
+
+// testLister allows listing a missing directory to return no entries,
+// since object stores like AWS S3 have no real directories.
+func testLister(t *testing.T, a adapter.Adapter) {
+	l, ok := a.(adapter.Lister)
+	if !ok {
+		t.Skip("adapter doesn't implement adapter.Lister")
+	}
+
+	write(t, a, "list/hello.txt", "Hello, world!")
+	write(t, a, "list/sub/deep/hello.txt", "deep")
+	createDir(t, a, "list/empty")
+
+	expectEntries(t, l, "list", false, []adapter.Entry{
+		{Path: "list/empty", Type: adapter.TypeDir},
+		{Path: "list/hello.txt", Type: adapter.TypeFile, Size: 13},
+		{Path: "list/sub", Type: adapter.TypeDir},
+	})
+
+	expectEntries(t, l, "list", true, []adapter.Entry{
+		{Path: "list/empty", Type: adapter.TypeDir},
+		{Path: "list/hello.txt", Type: adapter.TypeFile, Size: 13},
+		{Path: "list/sub", Type: adapter.TypeDir},
+		{Path: "list/sub/deep", Type: adapter.TypeDir},
+		{Path: "list/sub/deep/hello.txt", Type: adapter.TypeFile, Size: 4},
+	})
+
+	expectEntries(t, l, "list/sub/", false, []adapter.Entry{
+		{Path: "list/sub/deep", Type: adapter.TypeDir},
+	})
+
+	entries, err := l.ListContents("missing", false)
+	if err != nil && !errors.Is(err, adapter.ErrNotExist) {
+		t.Errorf("ListContents of a missing directory: expected nil or %v, got %v", adapter.ErrNotExist, err)
+	}
+
+	if len(entries) != 0 {
+		t.Errorf("ListContents of a missing directory: expected no entries, got %v", entries)
+	}
+}
+
+func testPostPolicyGenerator(t *testing.T, a adapter.Adapter) {
+	g, ok := a.(adapter.PostPolicyGenerator)
+	if !ok {
+		t.Skip("adapter doesn't implement adapter.PostPolicyGenerator")
+	}
+
+	policy, err := g.PostPolicy("dir", time.Hour, 0, 1024)
+	if errors.Is(err, adapter.ErrNotSupported) {
+		t.Skip("adapter doesn't support POST policies with its options")
+	}
+
+	if err != nil {
+		t.Fatalf("PostPolicy: unexpected error: %v", err)
+	}
+
+	if policy.URL == "" || len(policy.Fields) == 0 {
+		t.Errorf("PostPolicy: expected a URL and fields, got %+v", policy)
+	}
+}
+
+func testRangeReader(t *testing.T, a adapter.Adapter) {
+	r, ok := a.(adapter.RangeReader)
+	if !ok {
+		t.Skip("adapter doesn't implement adapter.RangeReader")
+	}
+
+	write(t, a, "dir/hello.txt", "Hello, world!")
+
+	tests := []struct {
+		offset   int64
+		length   int64
+		expected string
+	}{
+		{0, 5, "Hello"},
+		{7, 5, "world"},
+		{7, -1, "world!"},
+		{7, 100, "world!"},
+		{0, 0, ""},
+		{100, 5, ""},
+	}
+
+	for _, tt := range tests {
+		rc, err := r.ReadRange("dir/hello.txt", tt.offset, tt.length)
+		if err != nil {
+			t.Errorf("ReadRange %d-%d: unexpected error: %v", tt.offset, tt.length, err)
+			continue
+		}
+
+		content, err := ioutil.ReadAll(rc)
+		rc.Close()
+
+		if err != nil {
+			t.Errorf("ReadRange %d-%d: unexpected error: %v", tt.offset, tt.length, err)
+		}
+
+		if string(content) != tt.expected {
+			t.Errorf("ReadRange %d-%d: expected %q, got %q", tt.offset, tt.length, tt.expected, content)
+		}
+	}
+
+	_, err := r.ReadRange("dir/hello.txt", -1, 5)
+	expectError(t, "ReadRange with a negative offset", err, adapter.ErrInvalidRange)
+
+	_, err = r.ReadRange("missing.txt", 0, 5)
+	expectError(t, "ReadRange of a missing file", err, adapter.ErrNotExist)
+}
+
+func testRenamer(t *testing.T, a adapter.Adapter) {
+	r, ok := a.(adapter.Renamer)
+	if !ok {
+		t.Skip("adapter doesn't implement adapter.Renamer")
+	}
+
+	write(t, a, "hello.txt", "Hello, world!")
+	write(t, a, "existing.txt", "existing")
+
+	if err := r.Rename("hello.txt", "missing/parent/renamed.txt"); err != nil {
+		t.Fatalf("Rename to a missing parent directory: unexpected error: %v", err)
+	}
+
+	expectHas(t, a, "hello.txt", false)
+	expectContent(t, a, "missing/parent/renamed.txt", "Hello, world!")
+
+	if err := r.Rename("missing/parent/renamed.txt", "existing.txt"); err != nil {
+		t.Fatalf("Rename over a existing file: unexpected error: %v", err)
+	}
+
+	expectHas(t, a, "missing/parent/renamed.txt", false)
+	expectContent(t, a, "existing.txt", "Hello, world!")
+
+	if err := r.Rename("existing.txt", "unicode/renamed ✓.txt"); err != nil {
+		t.Fatalf("Rename to a unicode name: unexpected error: %v", err)
+	}
+
+	expectContent(t, a, "unicode/renamed ✓.txt", "Hello, world!")
+
+	err := r.Rename("missing.txt", "renamed.txt")
+	expectError(t, "Rename of a missing file", err, adapter.ErrNotExist)
+	expectHas(t, a, "renamed.txt", false)
+}
+
+func testServerSideCopier(t *testing.T, a adapter.Adapter) {
+	c, ok := a.(adapter.ServerSideCopier)
+	if !ok {
+		t.Skip("adapter doesn't implement adapter.ServerSideCopier")
+	}
+
+	write(t, a, "hello.txt", "Hello, world!")
+	write(t, a, "existing.txt", "existing")
+
+	if err := c.Copy("hello.txt", "missing/parent/copy.txt"); err != nil {
+		t.Fatalf("Copy to a missing parent directory: unexpected error: %v", err)
+	}
+
+	if err := c.Copy("hello.txt", "existing.txt"); err != nil {
+		t.Fatalf("Copy over a existing file: unexpected error: %v", err)
+	}
+
+	if err := c.Copy("hello.txt", "hello.txt"); err != nil {
+		t.Fatalf("Copy onto itself: unexpected error: %v", err)
+	}
+
+	expectContent(t, a, "hello.txt", "Hello, world!")
+	expectContent(t, a, "missing/parent/copy.txt", "Hello, world!")
+	expectContent(t, a, "existing.txt", "Hello, world!")
+
+	err := c.Copy("missing.txt", "copy.txt")
+	expectError(t, "Copy of a missing file", err, adapter.ErrNotExist)
+	expectHas(t, a, "copy.txt", false)
+}
+
+func testStater(t *testing.T, a adapter.Adapter) {
+	s, ok := a.(adapter.Stater)
+	if !ok {
+		t.Skip("adapter doesn't implement adapter.Stater")
+	}
+
+	write(t, a, "dir/hello.txt", "Hello, world!")
+
+	info, err := s.Stat("dir/hello.txt")
+	if err != nil {
+		t.Fatalf("Stat: unexpected error: %v", err)
+	}
+
+	if info.Path != "dir/hello.txt" {
+		t.Errorf("Stat: expected path %q, got %q", "dir/hello.txt", info.Path)
+	}
+
+	if info.Size != 13 {
+		t.Errorf("Stat: expected size 13, got %d", info.Size)
+	}
+
+	if info.ETag == "" {
+		t.Errorf("Stat: expected a ETag")
+	}
+
+	if !strings.HasPrefix(info.MimeType, "text/plain") {
+		t.Errorf("Stat: expected text/plain, got %q", info.MimeType)
+	}
+
+	write(t, a, "dir/hello.txt", "Bye")
+
+	changed, err := s.Stat("dir/hello.txt")
+	if err != nil {
+		t.Fatalf("Stat: unexpected error: %v", err)
+	}
+
+	if changed.ETag == info.ETag {
+		t.Errorf("Stat: expected the ETag to change on write")
+	}
+
+	_, err = s.Stat("missing.txt")
+	expectError(t, "Stat of a missing file", err, adapter.ErrNotExist)
+}
+
+func testStreamer(t *testing.T, a adapter.Adapter) {
+	s, ok := a.(adapter.Streamer)
+	if !ok {
+		t.Skip("adapter doesn't implement adapter.Streamer")
+	}
+
+	// Hide any other methods so the adapter only gets a plain reader.
+	r := struct{ io.Reader }{strings.NewReader("Hello, stream!")}
+
+	if err := s.WriteStream("dir/stream.txt", r); err != nil {
+		t.Fatalf("WriteStream: unexpected error: %v", err)
+	}
+
+	expectContent(t, a, "dir/stream.txt", "Hello, stream!")
+
+	rc, err := s.ReadStream("dir/stream.txt")
+	if err != nil {
+		t.Fatalf("ReadStream: unexpected error: %v", err)
+	}
+
+	content, err := ioutil.ReadAll(rc)
+	rc.Close()
+
+	if err != nil {
+		t.Fatalf("ReadStream: unexpected error: %v", err)
+	}
+
+	if string(content) != "Hello, stream!" {
+		t.Errorf("ReadStream: expected %q, got %q", "Hello, stream!", content)
+	}
+
+	_, err = s.ReadStream("missing.txt")
+	expectError(t, "ReadStream of a missing file", err, adapter.ErrNotExist)
+}
+
+func testTemporaryURLGenerator(t *testing.T, a adapter.Adapter) {
+	g, ok := a.(adapter.TemporaryURLGenerator)
+	if !ok {
+		t.Skip("adapter doesn't implement adapter.TemporaryURLGenerator")
+	}
+
+	write(t, a, "dir/hello.txt", "Hello, world!")
+
+	u, err := g.TemporaryURL("dir/hello.txt", time.Hour)
+	if errors.Is(err, adapter.ErrNotSupported) {
+		t.Skip("adapter doesn't support temporary URLs with its options")
+	}
+
+	if err != nil {
+		t.Fatalf("TemporaryURL: unexpected error: %v", err)
+	}
+
+	if !strings.Contains(u, "dir/hello.txt") {
+		t.Errorf("TemporaryURL: expected the path in %q", u)
+	}
+
+	u, err = g.TemporaryUploadURL("dir/upload.txt", time.Hour)
+	if err != nil {
+		t.Fatalf("TemporaryUploadURL: unexpected error: %v", err)
+	}
+
+	if !strings.Contains(u, "dir/upload.txt") {
+		t.Errorf("TemporaryUploadURL: expected the path in %q", u)
+	}
+}
+
+func testURLGenerator(t *testing.T, a adapter.Adapter) {
+	g, ok := a.(adapter.URLGenerator)
+	if !ok {
+		t.Skip("adapter doesn't implement adapter.URLGenerator")
+	}
+
+	write(t, a, "dir/hello.txt", "Hello, world!")
+
+	u, err := g.URL("dir/hello.txt")
+	if err != nil {
+		t.Fatalf("URL: unexpected error: %v", err)
+	}
+
+	if !strings.Contains(u, "dir/hello.txt") {
+		t.Errorf("URL: expected the path in %q", u)
+	}
+}
+
+func testVisibility(t *testing.T, a adapter.Adapter) {
+	v, ok := a.(adapter.Visibility)
+	if !ok {
+		t.Skip("adapter doesn't implement adapter.Visibility")
+	}
+
+	write(t, a, "hello.txt", "Hello, world!")
+
+	for _, visibility := range []string{adapter.VisibilityPrivate, adapter.VisibilityPublic} {
+		if err := v.SetVisibility("hello.txt", visibility); err != nil {
+			t.Fatalf("SetVisibility %s: unexpected error: %v", visibility, err)
+		}
+
+		got, err := v.Visibility("hello.txt")
+		if err != nil {
+			t.Fatalf("Visibility: unexpected error: %v", err)
+		}
+
+		if got != visibility {
+			t.Errorf("Visibility: expected %q, got %q", visibility, got)
+		}
+	}
+
+	expectContent(t, a, "hello.txt", "Hello, world!")
+
+	err := v.SetVisibility("hello.txt", "everyone")
+	expectError(t, "SetVisibility with a invalid visibility", err, adapter.ErrInvalidVisibility)
+
+	err = v.SetVisibility("missing.txt", adapter.VisibilityPrivate)
+	expectError(t, "SetVisibility of a missing file", err, adapter.ErrNotExist)
+
+	_, err = v.Visibility("missing.txt")
+	expectError(t, "Visibility of a missing file", err, adapter.ErrNotExist)
+}
+
+func testReadOnly(t *testing.T, a adapter.Adapter) {
+	readOnly := map[string]error{
+		"CreateDir": a.CreateDir("dir"),
+		"Delete":    a.Delete("hello.txt"),
+		"DeleteDir": a.DeleteDir("dir"),
+		"Write":     a.Write("hello.txt", "Hello, world!"),
+	}
+
+	_, readOnly["ReadAndDelete"] = a.ReadAndDelete("hello.txt")
+
+	if c, ok := a.(adapter.ServerSideCopier); ok {
+		readOnly["Copy"] = c.Copy("src.txt", "dst.txt")
+	}
+
+	if r, ok := a.(adapter.Renamer); ok {
+		readOnly["Rename"] = r.Rename("src.txt", "dst.txt")
+	}
+
+	if cw, ok := a.(adapter.ConditionalWriter); ok {
+		readOnly["Update"] = cw.Update("hello.txt", "Hello, world!")
+		readOnly["WriteNew"] = cw.WriteNew("hello.txt", "Hello, world!")
+	}
+
+	if ed, ok := a.(adapter.EmptyDirDeleter); ok {
+		readOnly["DeleteEmptyDir"] = ed.DeleteEmptyDir("dir")
+	}
+
+	if v, ok := a.(adapter.Visibility); ok {
+		readOnly["SetVisibility"] = v.SetVisibility("hello.txt", adapter.VisibilityPrivate)
+	}
+
+	if s, ok := a.(adapter.Streamer); ok {
+		readOnly["WriteStream"] = s.WriteStream("hello.txt", strings.NewReader("Hello, world!"))
+	}
+
+	for name, err := range readOnly {
+		expectError(t, name, err, adapter.ErrReadOnly)
+	}
+
+	expectHas(t, a, "flytest-missing.txt", false)
+	expectHasDir(t, a, "flytest-missing", false)
+
+	_, err := a.Read("flytest-missing.txt")
+	expectError(t, "Read", err, adapter.ErrNotExist)
+}
+
+func write(t *testing.T, a adapter.Adapter, path, content string) {
+	t.Helper()
+
+	if err := a.Write(path, content); err != nil {
+		t.Fatalf("Write %s: unexpected error: %v", path, err)
+	}
+}
+
+func createDir(t *testing.T, a adapter.Adapter, path string) {
+	t.Helper()
+
+	if err := a.CreateDir(path); err != nil {
+		t.Fatalf("CreateDir %s: unexpected error: %v", path, err)
+	}
+}
+
+func expectContent(t *testing.T, a adapter.Adapter, path, expected string) {
+	t.Helper()
+
+	content, err := a.Read(path)
+	if err != nil {
+		t.Fatalf("Read %s: unexpected error: %v", path, err)
+	}
+
+	if content != expected {
+		t.Errorf("Read %s: expected %q, got %q", path, expected, content)
+	}
+}
+
+func expectHas(t *testing.T, a adapter.Adapter, path string, expected bool) {
+	t.Helper()
+
+	has, err := a.Has(path)
+	if err != nil {
+		t.Errorf("Has %s: unexpected error: %v", path, err)
+	}
+
+	if has != expected {
+		t.Errorf("Has %s: expected %v, got %v", path, expected, has)
+	}
+}
+
+func expectHasDir(t *testing.T, a adapter.Adapter, path string, expected bool) {
+	t.Helper()
+
+	has, err := a.HasDir(path)
+	if err != nil {
+		t.Errorf("HasDir %s: unexpected error: %v", path, err)
+	}
+
+	if has != expected {
+		t.Errorf("HasDir %s: expected %v, got %v", path, expected, has)
+	}
+}
+
+func expectError(t *testing.T, name string, err, target error) {
+	t.Helper()
+
+	if !errors.Is(err, target) {
+		t.Errorf("%s: expected %v, got %v", name, target, err)
+	}
+}
+
+// expectEntries compares the listed entries with the expected entries by
+// path, type and size. The order of the entries isn't part of the contract.
+func expectEntries(t *testing.T, l adapter.Lister, dir string, recursive bool, expected []adapter.Entry) {
+	t.Helper()
+
+	entries, err := l.ListContents(dir, recursive)
+	if err != nil {
+		t.Fatalf("ListContents %s: unexpected error: %v", dir, err)
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Path < entries[j].Path
+	})
+
+	if len(entries) != len(expected) {
+		t.Fatalf("ListContents %s: expected %d entries, got %v", dir, len(expected), entries)
+	}
+
+	for i, e := range expected {
+		got := entries[i]
+		if got.Path != e.Path || got.Type != e.Type || got.Size != e.Size {
+			t.Errorf("ListContents %s: expected %+v, got %+v", dir, e, got)
+		}
+	}
+}
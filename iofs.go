@@ -0,0 +1,319 @@
+package fly
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/frozzare/go-fly/adapter"
+)
+
+// FS returns a io/fs.FS on top of the filesystem, so it can be used with
+// http.FS, template.ParseFS and fs.WalkDir. The returned value also
+// implements fs.StatFS, fs.ReadDirFS and fs.ReadFileFS. Directory
+// listings require a adapter that implements adapter.Lister.
+func (f *Filesystem) FS() fs.FS {
+	return &ioFS{f}
+}
+
+type ioFS struct {
+	fs *Filesystem
+}
+
+// name maps a io/fs name onto a filesystem path.
+func (i *ioFS) name(op, name string) (string, error) {
+	// Backslashes are rejected since the filesystem treats them as separators.
+	if !fs.ValidPath(name) || strings.Contains(name, "\\") {
+		return "", &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+
+	if name == "." {
+		return "", nil
+	}
+
+	return name, nil
+}
+
+// Open opens the named file or directory.
+func (i *ioFS) Open(name string) (fs.File, error) {
+	info, err := i.stat("open", name)
+	if err != nil {
+		return nil, err
+	}
+
+	if info.IsDir() {
+		return &ioDir{fsys: i, name: name, info: info}, nil
+	}
+
+	return &ioFile{fs: i.fs, name: name, info: info}, nil
+}
+
+// Stat returns the file info of the named file or directory.
+func (i *ioFS) Stat(name string) (fs.FileInfo, error) {
+	info, err := i.stat("stat", name)
+	if err != nil {
+		return nil, err
+	}
+
+	return info, nil
+}
+
+func (i *ioFS) stat(op, name string) (*ioFileInfo, error) {
+	p, err := i.name(op, name)
+	if err != nil {
+		return nil, err
+	}
+
+	if p == "" {
+		return &ioFileInfo{name: ".", dir: true}, nil
+	}
+
+	stat, err := i.fs.Stat(p)
+	if err == nil {
+		return &ioFileInfo{
+			name:    path.Base(p),
+			size:    stat.Size,
+			modTime: stat.LastModified,
+		}, nil
+	}
+
+	if !errors.Is(err, ErrNotExist) && !errors.Is(err, ErrIsDir) {
+		return nil, &fs.PathError{Op: op, Path: name, Err: err}
+	}
+
+	has, err := i.fs.HasDir(p)
+	if err != nil {
+		return nil, &fs.PathError{Op: op, Path: name, Err: err}
+	}
+
+	if !has {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+
+	return &ioFileInfo{name: path.Base(p), dir: true}, nil
+}
+
+// ReadDir reads the named directory and returns its entries sorted by name.
+func (i *ioFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	p, err := i.name("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := i.fs.ListContents(p, false)
+	if err != nil {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: err}
+	}
+
+	list := make([]fs.DirEntry, 0, len(entries))
+	for _, entry := range entries {
+		list = append(list, newIOFileInfo(entry))
+	}
+
+	sort.Slice(list, func(a, b int) bool {
+		return list[a].Name() < list[b].Name()
+	})
+
+	return list, nil
+}
+
+// ReadFile reads the named file and returns its content.
+func (i *ioFS) ReadFile(name string) ([]byte, error) {
+	p, err := i.name("readfile", name)
+	if err != nil {
+		return nil, err
+	}
+
+	if p == "" {
+		return nil, &fs.PathError{Op: "readfile", Path: name, Err: ErrIsDir}
+	}
+
+	content, err := i.fs.Read(p)
+	if err != nil {
+		return nil, &fs.PathError{Op: "readfile", Path: name, Err: err}
+	}
+
+	return []byte(content), nil
+}
+
+// ioFileInfo implements both fs.FileInfo and fs.DirEntry.
+type ioFileInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+	dir     bool
+}
+
+// newIOFileInfo creates a file info from a listing entry. Directories have no
+// modification time, since not every adapter can tell it for a single directory.
+func newIOFileInfo(entry adapter.Entry) *ioFileInfo {
+	if entry.IsDir() {
+		return &ioFileInfo{name: path.Base(entry.Path), dir: true}
+	}
+
+	return &ioFileInfo{
+		name:    path.Base(entry.Path),
+		size:    entry.Size,
+		modTime: entry.LastModified,
+	}
+}
+
+func (i *ioFileInfo) Name() string               { return i.name }
+func (i *ioFileInfo) Size() int64                { return i.size }
+func (i *ioFileInfo) ModTime() time.Time         { return i.modTime }
+func (i *ioFileInfo) IsDir() bool                { return i.dir }
+func (i *ioFileInfo) Sys() interface{}           { return nil }
+func (i *ioFileInfo) Type() fs.FileMode          { return i.Mode().Type() }
+func (i *ioFileInfo) Info() (fs.FileInfo, error) { return i, nil }
+
+func (i *ioFileInfo) Mode() fs.FileMode {
+	if i.dir {
+		return fs.ModeDir | 0555
+	}
+
+	return 0444
+}
+
+// ioFile is a read only file that streams its content from the adapter.
+// Seeking closes the stream, the next read reopens it at the new offset.
+type ioFile struct {
+	fs     *Filesystem
+	name   string
+	info   *ioFileInfo
+	r      io.ReadCloser
+	offset int64
+	closed bool
+}
+
+func (f *ioFile) Stat() (fs.FileInfo, error) {
+	return f.info, nil
+}
+
+func (f *ioFile) Read(p []byte) (int, error) {
+	if f.closed {
+		return 0, &fs.PathError{Op: "read", Path: f.name, Err: fs.ErrClosed}
+	}
+
+	if f.offset >= f.info.size {
+		return 0, io.EOF
+	}
+
+	if f.r == nil {
+		r, err := f.fs.ReadStream(f.name)
+		if err != nil {
+			return 0, &fs.PathError{Op: "read", Path: f.name, Err: err}
+		}
+
+		if _, err := io.CopyN(ioutil.Discard, r, f.offset); err != nil {
+			r.Close()
+			return 0, &fs.PathError{Op: "read", Path: f.name, Err: err}
+		}
+
+		f.r = r
+	}
+
+	n, err := f.r.Read(p)
+	f.offset += int64(n)
+
+	return n, err
+}
+
+func (f *ioFile) Seek(offset int64, whence int) (int64, error) {
+	if f.closed {
+		return 0, &fs.PathError{Op: "seek", Path: f.name, Err: fs.ErrClosed}
+	}
+
+	switch whence {
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		offset += f.info.size
+	case io.SeekStart:
+	default:
+		return 0, &fs.PathError{Op: "seek", Path: f.name, Err: fs.ErrInvalid}
+	}
+
+	if offset < 0 {
+		return 0, &fs.PathError{Op: "seek", Path: f.name, Err: fs.ErrInvalid}
+	}
+
+	if offset != f.offset && f.r != nil {
+		f.r.Close()
+		f.r = nil
+	}
+
+	f.offset = offset
+
+	return offset, nil
+}
+
+func (f *ioFile) Close() error {
+	if f.closed {
+		return &fs.PathError{Op: "close", Path: f.name, Err: fs.ErrClosed}
+	}
+
+	f.closed = true
+
+	if f.r != nil {
+		return f.r.Close()
+	}
+
+	return nil
+}
+
+// ioDir is a directory opened through the io/fs bridge.
+type ioDir struct {
+	fsys    *ioFS
+	name    string
+	info    *ioFileInfo
+	entries []fs.DirEntry
+	read    bool
+}
+
+func (d *ioDir) Stat() (fs.FileInfo, error) {
+	return d.info, nil
+}
+
+func (d *ioDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.name, Err: ErrIsDir}
+}
+
+func (d *ioDir) Close() error {
+	return nil
+}
+
+func (d *ioDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	if !d.read {
+		entries, err := d.fsys.ReadDir(d.name)
+		if err != nil {
+			return nil, err
+		}
+
+		d.entries = entries
+		d.read = true
+	}
+
+	if n <= 0 {
+		entries := d.entries
+		d.entries = nil
+		return entries, nil
+	}
+
+	if len(d.entries) == 0 {
+		return nil, io.EOF
+	}
+
+	if n > len(d.entries) {
+		n = len(d.entries)
+	}
+
+	entries := d.entries[:n]
+	d.entries = d.entries[n:]
+
+	return entries, nil
+}
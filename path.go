@@ -0,0 +1,140 @@
+package fly
+
+import (
+	"path"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/frozzare/go-fly/adapter"
+	"golang.org/x/text/unicode/norm"
+)
+
+// WithPathNormalizer returns a shallow copy of the filesystem that applies
+// the normalizer to every path after it has been cleaned, e.g. to lower case
+// names. It replaces the normalizer of WithNFC.
+func (f *Filesystem) WithPathNormalizer(normalize func(string) string) *Filesystem {
+	f2 := *f
+	f2.normalize = normalize
+	return &f2
+}
+
+// WithNFC returns a shallow copy of the filesystem that applies Unicode NFC to
+// every path, so names that are composed differently, like macOS decomposes
+// them, always map to the same key on every adapter.
+func (f *Filesystem) WithNFC() *Filesystem {
+	return f.WithPathNormalizer(norm.NFC.String)
+}
+
+// WithPortableNames returns a shallow copy of the filesystem that rejects paths
+// with ErrInvalidPath unless every adapter can store them, so files written
+// through any adapter can be synced to any other. See PortablePath for the rules.
+func (f *Filesystem) WithPortableNames() *Filesystem {
+	f2 := *f
+	f2.portable = true
+	return &f2
+}
+
+// maxPortablePath and maxPortableName are the most bytes of a portable path,
+// which is the maximum size of a AWS S3 key, and of each of its names, which
+// is the maximum of most local filesystems.
+const (
+	maxPortablePath = 1024
+	maxPortableName = 255
+)
+
+// reservedNames are the device names Windows reserves, with or without extension.
+var reservedNames = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true,
+	"COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true,
+	"LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// PortablePath reports whether the cleaned path can be stored by every adapter.
+// A portable path is valid UTF-8 of at most 1024 bytes without control characters,
+// and its names are at most 255 bytes without any of <>:"|?*, don't end with a
+// dot or space and aren't a device name reserved by Windows, like CON or LPT1.
+func PortablePath(p string) bool {
+	if len(p) > maxPortablePath || !utf8.ValidString(p) {
+		return false
+	}
+
+	if strings.IndexFunc(p, unicode.IsControl) != -1 || strings.ContainsAny(p, `<>:"|?*`) {
+		return false
+	}
+
+	for _, name := range strings.Split(p, "/") {
+		if len(name) > maxPortableName || strings.HasSuffix(name, ".") || strings.HasSuffix(name, " ") {
+			return false
+		}
+
+		base := strings.ToUpper(strings.TrimSpace(strings.SplitN(name, ".", 2)[0]))
+		if reservedNames[base] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// CleanPath returns the canonical form of a path as it's passed to the adapters.
+// Backslashes are converted to slashes, the path is cleaned and leading and
+// trailing slashes are removed. The root is returned as a empty string.
+// Paths that contain a NUL byte or escape the root with .. are rejected
+// with ErrInvalidPath.
+func CleanPath(p string) (string, error) {
+	if strings.IndexByte(p, 0) != -1 {
+		return "", ErrInvalidPath
+	}
+
+	p = strings.Replace(p, "\\", "/", -1)
+	p = path.Clean(strings.TrimLeft(p, "/"))
+
+	if p == ".." || strings.HasPrefix(p, "../") {
+		return "", ErrInvalidPath
+	}
+
+	if p == "." {
+		return "", nil
+	}
+
+	return p, nil
+}
+
+// path returns the normalized path of a file or directory, the root is rejected.
+func (f *Filesystem) path(op, p string) (string, error) {
+	clean, err := f.dirPath(op, p)
+	if err != nil {
+		return "", err
+	}
+
+	if clean == "" {
+		return "", &adapter.PathError{Op: op, Path: p, Adapter: "fly", Err: ErrInvalidPath}
+	}
+
+	return clean, nil
+}
+
+// dirPath returns the normalized path of a directory, the root is allowed.
+func (f *Filesystem) dirPath(op, p string) (string, error) {
+	clean, err := CleanPath(p)
+	if err != nil {
+		return "", &adapter.PathError{Op: op, Path: p, Adapter: "fly", Err: err}
+	}
+
+	if f.normalize != nil {
+		// Clean again so a normalizer can't reintroduce a escape.
+		clean, err = CleanPath(f.normalize(clean))
+		if err != nil {
+			return "", &adapter.PathError{Op: op, Path: p, Adapter: "fly", Err: err}
+		}
+	}
+
+	if f.portable && !PortablePath(clean) {
+		return "", &adapter.PathError{Op: op, Path: p, Adapter: "fly", Err: ErrInvalidPath}
+	}
+
+	return clean, nil
+}